@@ -3,6 +3,8 @@ package ast
 import (
 	"bytes"
 	"lexer-parser/token"
+	"math/big"
+	"sort"
 	"strings"
 )
 
@@ -14,6 +16,10 @@ type Node interface {
 	TokenLiteral() string
 	// allow us to print AST nodes for debugging and to compare them with other AST nodes
 	String() string
+	// Pos is the position of the first character belonging to the node
+	Pos() token.Position
+	// End is the position immediately after the node
+	End() token.Position
 }
 
 type Statement interface {
@@ -30,6 +36,8 @@ type Expression interface {
 type Program struct {
 	// just a slice of AST nodes that implement the Statement interface.
 	Statements []Statement
+	// the comments of the statements, when the lexer kept them
+	Comments CommentMap
 }
 
 func (p *Program) String() string {
@@ -51,22 +59,69 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+// the program spans from its first statement to its last statement
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
+
+// endOf returns the end of node, or the end of fallback when the node is missing
+// (the parser leaves nodes empty when it meets a syntax error)
+func endOf(node Node, fallback token.Token) token.Position {
+	if node == nil {
+		return fallback.End
+	}
+	if end := node.End(); end.IsValid() {
+		return end
+	}
+	return fallback.End
+}
+
 // Name to hold the identifier of the binding and
 // Value for the expression that produces the value
 // let <Name> = <Value>
 type LetStatement struct {
-	Token token.Token // Token : "let"
-	Name  *Identifier
-	Value Expression
+	Token   token.Token // Token : "let" or "const"
+	Name    *Identifier
+	Pattern Pattern // set instead of Name by destructuring, `let [a, b] = ...`
+	Value   Expression
 }
 
+// a const binding can never be assigned nor declared again in the same scope
+func (ls *LetStatement) IsConst() bool { return ls.Token.Type == token.CONST }
+
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return endOf(ls.Value, ls.Token)
+	}
+	if ls.Name != nil {
+		return ls.Name.End()
+	}
+	if ls.Pattern != nil {
+		return ls.Pattern.End()
+	}
+	return ls.Token.End
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 
 	if ls.Value != nil {
@@ -88,6 +143,8 @@ type Identifier struct {
 func (i *Identifier) expressionNode()      {} // Expression Interface
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 
 // return <expression>;
 type ReturnStatement struct {
@@ -97,6 +154,8 @@ type ReturnStatement struct {
 
 func (i *ReturnStatement) statementNode()        {} // Statement Interface
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position  { return endOf(rs.ReturnValue, rs.Token) }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -109,6 +168,147 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// while (<Condition>) { <Body> }
+type WhileStatement struct {
+	Token     token.Token // Token : "while"
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return endOf(ws.Body, ws.Token)
+	}
+	return endOf(ws.Condition, ws.Token)
+}
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while ")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+
+	return out.String()
+}
+
+// for (<Init>; <Condition>; <Post>) { <Body> }, every part of the header may be left out
+type ForStatement struct {
+	Token     token.Token // Token : "for"
+	Init      Statement
+	Condition Expression
+	Post      Statement
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return endOf(fs.Body, fs.Token)
+	}
+	return fs.Token.End
+}
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	if fs.Init != nil {
+		// a let statement already ends with ";"
+		out.WriteString(strings.TrimSuffix(fs.Init.String(), ";"))
+	}
+	out.WriteString("; ")
+	if fs.Condition != nil {
+		out.WriteString(fs.Condition.String())
+	}
+	out.WriteString("; ")
+	if fs.Post != nil {
+		out.WriteString(strings.TrimSuffix(fs.Post.String(), ";"))
+	}
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// for (<Variable> in <Iterable>) { <Body> }
+type ForInStatement struct {
+	Token    token.Token // Token : "for"
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForInStatement) End() token.Position {
+	if fs.Body != nil {
+		return endOf(fs.Body, fs.Token)
+	}
+	return endOf(fs.Iterable, fs.Token)
+}
+func (fs *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+
+	return out.String()
+}
+
+// break;
+type BreakStatement struct {
+	Token token.Token // Token : "break"
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) End() token.Position  { return bs.Token.End }
+func (bs *BreakStatement) String() string       { return "break;" }
+
+// continue;
+type ContinueStatement struct {
+	Token token.Token // Token : "continue"
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) End() token.Position  { return cs.Token.End }
+func (cs *ContinueStatement) String() string       { return "continue;" }
+
+// throw <expression>; raises an error, see TryExpression
+type ThrowStatement struct {
+	Token token.Token // Token : "throw"
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) End() token.Position  { return endOf(ts.Value, ts.Token) }
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // the first fo the expression
 	Expression Expression
@@ -116,6 +316,13 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+func (es *ExpressionStatement) End() token.Position { return endOf(es.Expression, es.Token) }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -126,10 +333,21 @@ func (es *ExpressionStatement) String() string {
 type BlockStatement struct {
 	Token      token.Token // the { block
 	Statements []Statement
+	Rbrace     token.Token // the closing } token
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.End.IsValid() {
+		return bs.Rbrace.End
+	}
+	if len(bs.Statements) > 0 {
+		return endOf(bs.Statements[len(bs.Statements)-1], bs.Token)
+	}
+	return bs.Token.End
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -143,20 +361,42 @@ func (bs *BlockStatement) String() string {
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // set instead of Value when the literal does not fit in an int64
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type FunctionLiteral struct {
 	Token      token.Token // the 'fn' token
-	Parameters []*Identifier
+	Parameters []Pattern   // names, or patterns destructuring the arguments
 	Body       *BlockStatement
+	Name       string // the name the function is bound to by `let`, if any
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return endOf(fl.Body, fl.Token)
+	}
+	return fl.Token.End
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -175,6 +415,41 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// macro(<parameters>) { <body> }, the body gets the arguments unevaluated as quotes
+// and returns the quote of the code to put in place of the call, see evaluator.ExpandMacros
+type MacroLiteral struct {
+	Token      token.Token // the 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) Pos() token.Position  { return ml.Token.Pos }
+func (ml *MacroLiteral) End() token.Position {
+	if ml.Body != nil {
+		return endOf(ml.Body, ml.Token)
+	}
+	return ml.Token.End
+}
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(")")
+
+	out.WriteString(ml.Body.String())
+
+	return out.String()
+}
+
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, e.g. !
 	Operator string
@@ -183,6 +458,8 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position  { return endOf(pe.Right, pe.Token) }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -203,6 +480,13 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *InfixExpression) End() token.Position { return endOf(ie.Right, ie.Token) }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -215,6 +499,66 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// <expression> && <expression> or <expression> || <expression>,
+// the right side is only evaluated when the left one does not decide the result
+type LogicalExpression struct {
+	Token    token.Token // The operator token, && or ||
+	Left     Expression
+	Operator string
+	Right    Expression
+}
+
+func (le *LogicalExpression) expressionNode()      {}
+func (le *LogicalExpression) TokenLiteral() string { return le.Token.Literal }
+func (le *LogicalExpression) Pos() token.Position {
+	if le.Left != nil {
+		return le.Left.Pos()
+	}
+	return le.Token.Pos
+}
+func (le *LogicalExpression) End() token.Position { return endOf(le.Right, le.Token) }
+func (le *LogicalExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(le.Left.String())
+	out.WriteString(" " + le.Operator + " ")
+	out.WriteString(le.Right.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// <identifier> = <expression> or <expression>[<expression>] = <expression>,
+// the operator can also be +=, -=, *= or /=
+type AssignExpression struct {
+	Token    token.Token // The operator token, = or e.g. +=
+	Target   Expression  // *Identifier or *IndexExpression
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position {
+	if ae.Target != nil {
+		return ae.Target.Pos()
+	}
+	return ae.Token.Pos
+}
+func (ae *AssignExpression) End() token.Position { return endOf(ae.Value, ae.Token) }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type Boolean struct {
 	Token token.Token
 	Value bool
@@ -223,6 +567,8 @@ type Boolean struct {
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
 func (b *Boolean) String() string       { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
+func (b *Boolean) End() token.Position  { return b.Token.End }
 
 type IfExpression struct {
 	Token       token.Token // The 'if' token
@@ -233,6 +579,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return endOf(ie.Alternative, ie.Token)
+	}
+	if ie.Consequence != nil {
+		return endOf(ie.Consequence, ie.Token)
+	}
+	return endOf(ie.Condition, ie.Token)
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -250,14 +606,265 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// match (<subject>) { <pattern> [when <guard>] => <body>, ... },
+// the body of the first arm whose pattern matches and whose guard holds is the value
+type MatchExpression struct {
+	Token   token.Token // The 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+	Rbrace  token.Token // the closing } token
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) End() token.Position {
+	if me.Rbrace.End.IsValid() {
+		return me.Rbrace.End
+	}
+	if len(me.Arms) > 0 {
+		return endOf(me.Arms[len(me.Arms)-1], me.Token)
+	}
+	return endOf(me.Subject, me.Token)
+}
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := []string{}
+	for _, arm := range me.Arms {
+		arms = append(arms, arm.String())
+	}
+
+	out.WriteString("match ")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// <pattern> [when <guard>] => <body>, the body is a block or an expression statement
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil without `when`
+	Body    Statement
+}
+
+func (ma *MatchArm) TokenLiteral() string { return ma.Pattern.TokenLiteral() }
+func (ma *MatchArm) Pos() token.Position  { return ma.Pattern.Pos() }
+func (ma *MatchArm) End() token.Position {
+	if ma.Body != nil {
+		return ma.Body.End()
+	}
+	return ma.Pattern.End()
+}
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ma.Pattern.String())
+	if ma.Guard != nil {
+		out.WriteString(" when ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(ma.Body.String())
+
+	return out.String()
+}
+
+// try { <block> } catch (<param>) { <block> } finally { <block> },
+// catch or finally may be left out but not both. The value is the one of the block
+// or, when it raised an error, the one of the catch block
+type TryExpression struct {
+	Token   token.Token // The 'try' token
+	Block   *BlockStatement
+	Param   *Identifier // the caught error, nil without catch
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) End() token.Position {
+	if te.Finally != nil {
+		return endOf(te.Finally, te.Token)
+	}
+	if te.Catch != nil {
+		return endOf(te.Catch, te.Token)
+	}
+	return endOf(te.Block, te.Token)
+}
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Block.String())
+
+	if te.Catch != nil {
+		out.WriteString(" catch (" + te.Param.String() + ") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
+// Patterns describe the shape of a value and bind names to its parts
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// _ matches anything
+type WildcardPattern struct {
+	Token token.Token // the '_' token
+}
+
+func (wp *WildcardPattern) patternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string       { return wp.Token.Literal }
+func (wp *WildcardPattern) Pos() token.Position  { return wp.Token.Pos }
+func (wp *WildcardPattern) End() token.Position  { return wp.Token.End }
+
+// a name matches anything and is bound to the value
+type BindingPattern struct {
+	Name *Identifier
+}
+
+func (bp *BindingPattern) patternNode()         {}
+func (bp *BindingPattern) TokenLiteral() string { return bp.Name.TokenLiteral() }
+func (bp *BindingPattern) String() string       { return bp.Name.String() }
+func (bp *BindingPattern) Pos() token.Position  { return bp.Name.Pos() }
+func (bp *BindingPattern) End() token.Position  { return bp.Name.End() }
+
+// an integer, float, string or boolean literal, possibly negated, matches equal values
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+func (lp *LiteralPattern) Pos() token.Position  { return lp.Value.Pos() }
+func (lp *LiteralPattern) End() token.Position  { return lp.Value.End() }
+
+// <pattern> = <expression> gives the value of the expression to the pattern
+// when the part of the value it destructures is missing
+type DefaultPattern struct {
+	Pattern Pattern
+	Default Expression
+}
+
+func (dp *DefaultPattern) patternNode()         {}
+func (dp *DefaultPattern) TokenLiteral() string { return dp.Pattern.TokenLiteral() }
+func (dp *DefaultPattern) String() string       { return dp.Pattern.String() + " = " + dp.Default.String() }
+func (dp *DefaultPattern) Pos() token.Position  { return dp.Pattern.Pos() }
+func (dp *DefaultPattern) End() token.Position {
+	if dp.Default != nil {
+		return dp.Default.End()
+	}
+	return dp.Pattern.End()
+}
+
+// ...<identifier> is bound to an array of the elements left, the last of an array pattern
+type RestPattern struct {
+	Token token.Token // the '...' token
+	Name  *Identifier
+}
+
+func (rp *RestPattern) patternNode()         {}
+func (rp *RestPattern) TokenLiteral() string { return rp.Token.Literal }
+func (rp *RestPattern) String() string       { return "..." + rp.Name.String() }
+func (rp *RestPattern) Pos() token.Position  { return rp.Token.Pos }
+func (rp *RestPattern) End() token.Position  { return rp.Name.End() }
+
+// <pattern> | <pattern> matches when one of the alternatives does, tried from left to right
+type AlternativePattern struct {
+	Alternatives []Pattern
+}
+
+func (ap *AlternativePattern) patternNode()         {}
+func (ap *AlternativePattern) TokenLiteral() string { return ap.Alternatives[0].TokenLiteral() }
+func (ap *AlternativePattern) Pos() token.Position  { return ap.Alternatives[0].Pos() }
+func (ap *AlternativePattern) End() token.Position {
+	return ap.Alternatives[len(ap.Alternatives)-1].End()
+}
+func (ap *AlternativePattern) String() string {
+	alternatives := []string{}
+	for _, alt := range ap.Alternatives {
+		alternatives = append(alternatives, alt.String())
+	}
+	return strings.Join(alternatives, " | ")
+}
+
+// [<pattern>, ...] matches arrays of the same length whose elements match,
+// or at least as long when the last pattern is a rest pattern
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []Pattern
+	Rbracket token.Token // the closing ']' token
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) End() token.Position  { return ap.Rbracket.End }
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// {<literal>: <pattern>, ...} matches hashes having every key with a matching value,
+// other keys are ignored. The keys are kept in source order. A name as key
+// stands for a string, and `{name}` is short for `{"name": name}`.
+type HashPattern struct {
+	Token  token.Token // the '{' token
+	Keys   []Expression
+	Values []Pattern
+	Rbrace token.Token // the closing '}' token
+}
+
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position  { return hp.Rbrace.End }
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for i, key := range hp.Keys {
+		pairs = append(pairs, key.String()+": "+hp.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
 type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLIteral
 	Arguments []Expression
+	Rparen    token.Token // the closing ')' token
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+func (ce *CallExpression) End() token.Position {
+	if ce.Rparen.End.IsValid() {
+		return ce.Rparen.End
+	}
+	return ce.Token.End
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -274,6 +881,30 @@ func (ce *CallExpression) String() string {
 	return out.String()
 }
 
+// ...<expression> in a call or an array literal, the elements of the array take its place
+type SpreadExpression struct {
+	Token token.Token // the '...' token
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode()      {}
+func (se *SpreadExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SpreadExpression) String() string       { return "..." + se.Value.String() }
+func (se *SpreadExpression) Pos() token.Position  { return se.Token.Pos }
+func (se *SpreadExpression) End() token.Position  { return endOf(se.Value, se.Token) }
+
+// <identifier>: <expression> in a call, the argument of the parameter with that name
+type NamedArgument struct {
+	Name  *Identifier
+	Value Expression
+}
+
+func (na *NamedArgument) expressionNode()      {}
+func (na *NamedArgument) TokenLiteral() string { return na.Name.TokenLiteral() }
+func (na *NamedArgument) String() string       { return na.Name.String() + ": " + na.Value.String() }
+func (na *NamedArgument) Pos() token.Position  { return na.Name.Pos() }
+func (na *NamedArgument) End() token.Position  { return endOf(na.Value, na.Name.Token) }
+
 type StringLiteral struct {
 	Token token.Token
 	Value string
@@ -282,14 +913,24 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
 
 type ArrayLiteral struct {
 	Token    token.Token // the '[' token
 	Elements []Expression
+	Rbracket token.Token // the closing ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position {
+	if al.Rbracket.End.IsValid() {
+		return al.Rbracket.End
+	}
+	return al.Token.End
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -306,13 +947,26 @@ func (al *ArrayLiteral) String() string {
 // <expression>[<expression>]
 // [1, 2, 3, 4][2]
 type IndexExpression struct {
-	Token token.Token // the '[' token
-	Left  Expression
-	Index Expression
+	Token    token.Token // the '[' token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token // the closing ']' token
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *IndexExpression) End() token.Position {
+	if ie.Rbracket.End.IsValid() {
+		return ie.Rbracket.End
+	}
+	return endOf(ie.Index, ie.Token)
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -326,12 +980,20 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token // the '{' token
-	Pairs map[Expression]Expression
+	Token  token.Token // the '{' token
+	Pairs  map[Expression]Expression
+	Rbrace token.Token // the closing '}' token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position {
+	if hl.Rbrace.End.IsValid() {
+		return hl.Rbrace.End
+	}
+	return hl.Token.End
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
@@ -344,3 +1006,49 @@ func (hl *HashLiteral) String() string {
 	out.WriteString("}")
 	return out.String()
 }
+
+// Keys returns the keys of the pairs in the order they were written
+func (hl *HashLiteral) Keys() []Expression {
+	keys := make([]Expression, 0, len(hl.Pairs))
+	for key := range hl.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i].Pos(), keys[j].Pos()
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		// keys made up without positions, e.g. by unquote, keep a stable order
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// BadStatement is a placeholder for a statement containing syntax errors,
+// it covers the tokens the parser skipped while recovering.
+type BadStatement struct {
+	Token token.Token // the first token of the broken statement
+	To    token.Token // the last token skipped
+}
+
+func (bs *BadStatement) statementNode()       {}
+func (bs *BadStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BadStatement) String() string       { return "<bad statement>" }
+func (bs *BadStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BadStatement) End() token.Position {
+	if bs.To.End.IsValid() {
+		return bs.To.End
+	}
+	return bs.Token.End
+}
+
+// BadExpression is a placeholder for an expression containing syntax errors
+type BadExpression struct {
+	Token token.Token // the token the broken expression starts with
+}
+
+func (be *BadExpression) expressionNode()      {}
+func (be *BadExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BadExpression) String() string       { return "<bad expression>" }
+func (be *BadExpression) Pos() token.Position  { return be.Token.Pos }
+func (be *BadExpression) End() token.Position  { return be.Token.End }
@@ -0,0 +1,171 @@
+package ast
+
+import (
+	"lexer-parser/token"
+)
+
+// Comment is a `// line` or a `/* block */` comment, kept for tools like a formatter.
+// Comments are not part of the tree, a CommentMap says which node they belong to
+type Comment struct {
+	Token token.Token // the COMMENT token, its literal is the whole comment
+}
+
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+func (c *Comment) Pos() token.Position  { return c.Token.Pos }
+func (c *Comment) End() token.Position  { return c.Token.End }
+func (c *Comment) String() string       { return c.Token.Literal }
+func (c *Comment) IsBlock() bool        { return len(c.Token.Literal) > 1 && c.Token.Literal[1] == '*' }
+
+// Comments are the comments of a node: the ones on the lines before it,
+// and the ones after it on its last line and below, before the next node.
+// A block, a match or a program without anything in it has the comments
+// inside it as leading ones
+type Comments struct {
+	Leading  []*Comment
+	Trailing []*Comment
+}
+
+// CommentMap holds the comments of the statements, match arms, array elements,
+// hash values, arguments and parameters of a program, and of the blocks
+// a comment follows on their last line
+type CommentMap map[Node]*Comments
+
+func (cm CommentMap) leading(node Node, c *Comment) {
+	cm.of(node).Leading = append(cm.of(node).Leading, c)
+}
+
+func (cm CommentMap) trailing(node Node, c *Comment) {
+	cm.of(node).Trailing = append(cm.of(node).Trailing, c)
+}
+
+func (cm CommentMap) of(node Node) *Comments {
+	if cm[node] == nil {
+		cm[node] = &Comments{}
+	}
+	return cm[node]
+}
+
+// an item of a list in the tree: the node its comments go to and the source it spans,
+// a hash value spans its key too
+type item struct {
+	node     Node
+	pos, end token.Position
+}
+
+func itemsOf(n int, node func(i int) Node) []item {
+	items := make([]item, n)
+	for i := range items {
+		items[i] = item{node: node(i), pos: node(i).Pos(), end: node(i).End()}
+	}
+	return items
+}
+
+// NewCommentMap gives every comment of program to the item next to it in the innermost
+// list holding the comment: the statements of a block or the program, the arms of a match,
+// the elements of an array or a hash, the arguments of a call or the parameters of a function.
+// A comment in the middle of an item is a leading one of the item, unless it follows
+// a block of the item on the block's last line, like `} // then` before an else.
+func NewCommentMap(program *Program, comments []*Comment) CommentMap {
+	cm := CommentMap{}
+	for _, c := range comments {
+		var container Node = program
+		items := itemsOf(len(program.Statements), func(i int) Node { return program.Statements[i] })
+
+		Inspect(program, func(node Node) bool {
+			if node == nil || node == Node(program) {
+				return true
+			}
+			if !contains(node, c) {
+				return false
+			}
+			switch node := node.(type) {
+			case *BlockStatement:
+				container, items = node, itemsOf(len(node.Statements), func(i int) Node { return node.Statements[i] })
+			case *MatchExpression:
+				container, items = node, itemsOf(len(node.Arms), func(i int) Node { return node.Arms[i] })
+			case *ArrayLiteral:
+				if len(node.Elements) > 0 {
+					container, items = node, itemsOf(len(node.Elements), func(i int) Node { return node.Elements[i] })
+				}
+			case *HashLiteral:
+				if len(node.Pairs) > 0 {
+					container, items = node, nil
+					for _, key := range node.Keys() {
+						value := node.Pairs[key]
+						items = append(items, item{node: value, pos: key.Pos(), end: value.End()})
+					}
+				}
+			case *CallExpression:
+				if len(node.Arguments) > 0 && node.Token.Pos.Offset <= c.Pos().Offset {
+					container, items = node, itemsOf(len(node.Arguments), func(i int) Node { return node.Arguments[i] })
+				}
+			case *FunctionLiteral:
+				if len(node.Parameters) > 0 && c.End().Offset <= node.Body.Pos().Offset {
+					container, items = node, itemsOf(len(node.Parameters), func(i int) Node { return node.Parameters[i] })
+				}
+			}
+			return true
+		})
+
+		cm.attach(container, items, c)
+	}
+	return cm
+}
+
+func (cm CommentMap) attach(container Node, items []item, c *Comment) {
+	var prev, next *item
+	for i := range items {
+		item := &items[i]
+		switch {
+		case item.pos.Offset <= c.Pos().Offset && c.End().Offset <= item.end.Offset:
+			if block := blockBefore(item.node, c); block != nil {
+				cm.trailing(block, c)
+			} else {
+				cm.leading(item.node, c)
+			}
+			return
+		case item.end.Offset <= c.Pos().Offset:
+			prev = item
+		case next == nil:
+			next = item
+		}
+	}
+
+	// a block comment right before the next item is about that one
+	before := c.IsBlock() && next != nil && next.pos.Line == c.End().Line
+	switch {
+	case prev != nil && prev.end.Line == c.Pos().Line && !before:
+		cm.trailing(prev.node, c)
+	case next != nil:
+		cm.leading(next.node, c)
+	case prev != nil:
+		cm.trailing(prev.node, c)
+	default:
+		cm.leading(container, c)
+	}
+}
+
+// the block of node the comment follows on the block's last line, with nothing between them
+func blockBefore(node Node, c *Comment) *BlockStatement {
+	var found *BlockStatement
+	last := -1
+	Inspect(node, func(n Node) bool {
+		if n == nil || !n.End().IsValid() || n.End().Offset > c.Pos().Offset {
+			return n != nil
+		}
+		if n.End().Offset > last {
+			last, found = n.End().Offset, nil
+		}
+		if block, ok := n.(*BlockStatement); ok && n.End().Offset == last && n.End().Line == c.Pos().Line {
+			found = block
+		}
+		return true
+	})
+	return found
+}
+
+// whether the comment is within the source of node
+func contains(node Node, c *Comment) bool {
+	pos, end := node.Pos(), node.End()
+	return pos.IsValid() && end.IsValid() && pos.Offset <= c.Pos().Offset && c.End().Offset <= end.Offset
+}
@@ -0,0 +1,422 @@
+package ast
+
+import "lexer-parser/token"
+
+// Rewrite walks the tree below node depth first, children before their parent, and replaces
+// every node with what f returns for it. The nodes on the way are copied, so node is left
+// as it is and a function body can be rewritten again each time it runs.
+// A replacement that does not fit where the node was, e.g. a statement in place of an
+// expression, is ignored.
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch node := node.(type) {
+	case *Program:
+		c := *node
+		c.Statements = rewriteStatements(node.Statements, f)
+		return f(&c)
+	case *ExpressionStatement:
+		c := *node
+		c.Expression = rewriteExpression(node.Expression, f)
+		return f(&c)
+	case *BlockStatement:
+		c := *node
+		c.Statements = rewriteStatements(node.Statements, f)
+		return f(&c)
+	case *LetStatement:
+		c := *node
+		c.Name = rewriteIdentifier(node.Name, f)
+		c.Pattern = rewritePattern(node.Pattern, f)
+		c.Value = rewriteExpression(node.Value, f)
+		return f(&c)
+	case *ReturnStatement:
+		c := *node
+		c.ReturnValue = rewriteExpression(node.ReturnValue, f)
+		return f(&c)
+	case *ThrowStatement:
+		c := *node
+		c.Value = rewriteExpression(node.Value, f)
+		return f(&c)
+	case *WhileStatement:
+		c := *node
+		c.Condition = rewriteExpression(node.Condition, f)
+		c.Body = rewriteBlock(node.Body, f)
+		return f(&c)
+	case *ForStatement:
+		c := *node
+		c.Init = rewriteStatement(node.Init, f)
+		c.Condition = rewriteExpression(node.Condition, f)
+		c.Post = rewriteStatement(node.Post, f)
+		c.Body = rewriteBlock(node.Body, f)
+		return f(&c)
+	case *ForInStatement:
+		c := *node
+		c.Variable = rewriteIdentifier(node.Variable, f)
+		c.Iterable = rewriteExpression(node.Iterable, f)
+		c.Body = rewriteBlock(node.Body, f)
+		return f(&c)
+	case *PrefixExpression:
+		c := *node
+		c.Right = rewriteExpression(node.Right, f)
+		return f(&c)
+	case *InfixExpression:
+		c := *node
+		c.Left = rewriteExpression(node.Left, f)
+		c.Right = rewriteExpression(node.Right, f)
+		return f(&c)
+	case *LogicalExpression:
+		c := *node
+		c.Left = rewriteExpression(node.Left, f)
+		c.Right = rewriteExpression(node.Right, f)
+		return f(&c)
+	case *AssignExpression:
+		c := *node
+		c.Target = rewriteExpression(node.Target, f)
+		c.Value = rewriteExpression(node.Value, f)
+		return f(&c)
+	case *IfExpression:
+		c := *node
+		c.Condition = rewriteExpression(node.Condition, f)
+		c.Consequence = rewriteBlock(node.Consequence, f)
+		c.Alternative = rewriteBlock(node.Alternative, f)
+		return f(&c)
+	case *MatchExpression:
+		c := *node
+		c.Subject = rewriteExpression(node.Subject, f)
+		c.Arms = make([]*MatchArm, len(node.Arms))
+		for i, arm := range node.Arms {
+			c.Arms[i] = arm
+			if rewritten, ok := Rewrite(arm, f).(*MatchArm); ok {
+				c.Arms[i] = rewritten
+			}
+		}
+		return f(&c)
+	case *MatchArm:
+		c := *node
+		c.Pattern = rewritePattern(node.Pattern, f)
+		c.Guard = rewriteExpression(node.Guard, f)
+		c.Body = rewriteStatement(node.Body, f)
+		return f(&c)
+	case *TryExpression:
+		c := *node
+		c.Block = rewriteBlock(node.Block, f)
+		c.Param = rewriteIdentifier(node.Param, f)
+		c.Catch = rewriteBlock(node.Catch, f)
+		c.Finally = rewriteBlock(node.Finally, f)
+		return f(&c)
+	case *FunctionLiteral:
+		c := *node
+		c.Parameters = rewritePatterns(node.Parameters, f)
+		c.Body = rewriteBlock(node.Body, f)
+		return f(&c)
+	case *MacroLiteral:
+		c := *node
+		c.Parameters = make([]*Identifier, len(node.Parameters))
+		for i, param := range node.Parameters {
+			c.Parameters[i] = rewriteIdentifier(param, f)
+		}
+		c.Body = rewriteBlock(node.Body, f)
+		return f(&c)
+	case *CallExpression:
+		c := *node
+		c.Function = rewriteExpression(node.Function, f)
+		c.Arguments = rewriteExpressions(node.Arguments, f)
+		return f(&c)
+	case *SpreadExpression:
+		c := *node
+		c.Value = rewriteExpression(node.Value, f)
+		return f(&c)
+	case *NamedArgument:
+		c := *node
+		c.Name = rewriteIdentifier(node.Name, f)
+		c.Value = rewriteExpression(node.Value, f)
+		return f(&c)
+	case *ArrayLiteral:
+		c := *node
+		c.Elements = rewriteExpressions(node.Elements, f)
+		return f(&c)
+	case *IndexExpression:
+		c := *node
+		c.Left = rewriteExpression(node.Left, f)
+		c.Index = rewriteExpression(node.Index, f)
+		return f(&c)
+	case *HashLiteral:
+		c := *node
+		c.Pairs = make(map[Expression]Expression, len(node.Pairs))
+		for _, key := range node.Keys() {
+			c.Pairs[rewriteExpression(key, f)] = rewriteExpression(node.Pairs[key], f)
+		}
+		return f(&c)
+	case *BindingPattern:
+		c := *node
+		c.Name = rewriteIdentifier(node.Name, f)
+		return f(&c)
+	case *RestPattern:
+		c := *node
+		c.Name = rewriteIdentifier(node.Name, f)
+		return f(&c)
+	case *LiteralPattern:
+		c := *node
+		c.Value = rewriteExpression(node.Value, f)
+		return f(&c)
+	case *DefaultPattern:
+		c := *node
+		c.Pattern = rewritePattern(node.Pattern, f)
+		c.Default = rewriteExpression(node.Default, f)
+		return f(&c)
+	case *AlternativePattern:
+		c := *node
+		c.Alternatives = rewritePatterns(node.Alternatives, f)
+		return f(&c)
+	case *ArrayPattern:
+		c := *node
+		c.Elements = rewritePatterns(node.Elements, f)
+		return f(&c)
+	case *HashPattern:
+		c := *node
+		c.Keys = make([]Expression, len(node.Keys))
+		c.Values = make([]Pattern, len(node.Values))
+		for i := range node.Keys {
+			c.Keys[i] = rewriteExpression(node.Keys[i], f)
+			if i < len(node.Values) {
+				c.Values[i] = rewritePattern(node.Values[i], f)
+			}
+		}
+		return f(&c)
+	}
+
+	// identifiers, literals and the placeholders of syntax errors have no children
+	return f(node)
+}
+
+// the helpers keep what was there when the rewritten node is of the wrong kind,
+// and leave the optional parts of a node nil
+
+func rewriteStatement(stmt Statement, f func(Node) Node) Statement {
+	if stmt == nil {
+		return nil
+	}
+	if rewritten, ok := Rewrite(stmt, f).(Statement); ok {
+		return rewritten
+	}
+	return stmt
+}
+
+func rewriteExpression(exp Expression, f func(Node) Node) Expression {
+	if exp == nil {
+		return nil
+	}
+	if rewritten, ok := Rewrite(exp, f).(Expression); ok {
+		return rewritten
+	}
+	return exp
+}
+
+func rewritePattern(pattern Pattern, f func(Node) Node) Pattern {
+	if pattern == nil {
+		return nil
+	}
+	if rewritten, ok := Rewrite(pattern, f).(Pattern); ok {
+		return rewritten
+	}
+	return pattern
+}
+
+func rewriteBlock(block *BlockStatement, f func(Node) Node) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	if rewritten, ok := Rewrite(block, f).(*BlockStatement); ok {
+		return rewritten
+	}
+	return block
+}
+
+func rewriteIdentifier(ident *Identifier, f func(Node) Node) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	if rewritten, ok := Rewrite(ident, f).(*Identifier); ok {
+		return rewritten
+	}
+	return ident
+}
+
+func rewriteStatements(stmts []Statement, f func(Node) Node) []Statement {
+	rewritten := make([]Statement, len(stmts))
+	for i, stmt := range stmts {
+		rewritten[i] = rewriteStatement(stmt, f)
+	}
+	return rewritten
+}
+
+func rewriteExpressions(exps []Expression, f func(Node) Node) []Expression {
+	rewritten := make([]Expression, len(exps))
+	for i, exp := range exps {
+		rewritten[i] = rewriteExpression(exp, f)
+	}
+	return rewritten
+}
+
+func rewritePatterns(patterns []Pattern, f func(Node) Node) []Pattern {
+	rewritten := make([]Pattern, len(patterns))
+	for i, pattern := range patterns {
+		rewritten[i] = rewritePattern(pattern, f)
+	}
+	return rewritten
+}
+
+// At returns a copy of node with its tokens at pos to end, the children are left as they are.
+// Code a macro puts in place of its call is moved to the call this way
+func At(node Node, pos, end token.Position) Node {
+	at := func(tok token.Token) token.Token {
+		tok.Pos, tok.End = pos, end
+		return tok
+	}
+
+	switch node := node.(type) {
+	case *LetStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *Identifier:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ReturnStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *WhileStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ForStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ForInStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *BreakStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ContinueStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ThrowStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ExpressionStatement:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *BlockStatement:
+		c := *node
+		c.Token, c.Rbrace = at(node.Token), at(node.Rbrace)
+		return &c
+	case *IntegerLiteral:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *FloatLiteral:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *FunctionLiteral:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *MacroLiteral:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *PrefixExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *InfixExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *LogicalExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *AssignExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *Boolean:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *IfExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *MatchExpression:
+		c := *node
+		c.Token, c.Rbrace = at(node.Token), at(node.Rbrace)
+		return &c
+	case *TryExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *WildcardPattern:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *RestPattern:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ArrayPattern:
+		c := *node
+		c.Token, c.Rbracket = at(node.Token), at(node.Rbracket)
+		return &c
+	case *HashPattern:
+		c := *node
+		c.Token, c.Rbrace = at(node.Token), at(node.Rbrace)
+		return &c
+	case *CallExpression:
+		c := *node
+		c.Token, c.Rparen = at(node.Token), at(node.Rparen)
+		return &c
+	case *SpreadExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *StringLiteral:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *ArrayLiteral:
+		c := *node
+		c.Token, c.Rbracket = at(node.Token), at(node.Rbracket)
+		return &c
+	case *IndexExpression:
+		c := *node
+		c.Token, c.Rbracket = at(node.Token), at(node.Rbracket)
+		return &c
+	case *HashLiteral:
+		c := *node
+		c.Token, c.Rbrace = at(node.Token), at(node.Rbrace)
+		return &c
+	case *BadStatement:
+		c := *node
+		c.Token, c.To = at(node.Token), at(node.To)
+		return &c
+	case *BadExpression:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	case *Comment:
+		c := *node
+		c.Token = at(node.Token)
+		return &c
+	}
+	return node
+}
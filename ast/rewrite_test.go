@@ -0,0 +1,191 @@
+package ast
+
+import (
+	"lexer-parser/token"
+	"reflect"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	one := func() Expression { return &IntegerLiteral{Value: 1} }
+	two := func() Expression { return &IntegerLiteral{Value: 2} }
+
+	turnOneIntoTwo := func(node Node) Node {
+		integer, ok := node.(*IntegerLiteral)
+		if !ok {
+			return node
+		}
+		if integer.Value != 1 {
+			return node
+		}
+		integer.Value = 2
+		return integer
+	}
+
+	tests := []struct {
+		input    Node
+		expected Node
+	}{
+		{one(), two()},
+		{
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			&Program{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+		},
+		{
+			&InfixExpression{Left: one(), Operator: "+", Right: two()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&InfixExpression{Left: two(), Operator: "+", Right: one()},
+			&InfixExpression{Left: two(), Operator: "+", Right: two()},
+		},
+		{
+			&PrefixExpression{Operator: "-", Right: one()},
+			&PrefixExpression{Operator: "-", Right: two()},
+		},
+		{
+			&IndexExpression{Left: one(), Index: one()},
+			&IndexExpression{Left: two(), Index: two()},
+		},
+		{
+			&IfExpression{
+				Condition:   one(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&IfExpression{
+				Condition:   two(),
+				Consequence: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Alternative: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+		{&ReturnStatement{ReturnValue: one()}, &ReturnStatement{ReturnValue: two()}},
+		{&ThrowStatement{Value: one()}, &ThrowStatement{Value: two()}},
+		{&LetStatement{Value: one()}, &LetStatement{Value: two()}},
+		{
+			&FunctionLiteral{
+				Parameters: []Pattern{&DefaultPattern{Pattern: &BindingPattern{Name: &Identifier{Value: "a"}}, Default: one()}},
+				Body:       &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&FunctionLiteral{
+				Parameters: []Pattern{&DefaultPattern{Pattern: &BindingPattern{Name: &Identifier{Value: "a"}}, Default: two()}},
+				Body:       &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+		{
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{one(), &SpreadExpression{Value: one()}}},
+			&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{two(), &SpreadExpression{Value: two()}}},
+		},
+		{&ArrayLiteral{Elements: []Expression{one(), one()}}, &ArrayLiteral{Elements: []Expression{two(), two()}}},
+		{
+			&WhileStatement{Condition: one(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}}},
+			&WhileStatement{Condition: two(), Body: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}}},
+		},
+		{
+			&MatchExpression{Subject: one(), Arms: []*MatchArm{
+				{Pattern: &LiteralPattern{Value: one()}, Guard: one(), Body: &ExpressionStatement{Expression: one()}},
+			}},
+			&MatchExpression{Subject: two(), Arms: []*MatchArm{
+				{Pattern: &LiteralPattern{Value: two()}, Guard: two(), Body: &ExpressionStatement{Expression: two()}},
+			}},
+		},
+		{
+			&TryExpression{
+				Block:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Param:   &Identifier{Value: "e"},
+				Catch:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+				Finally: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: one()}}},
+			},
+			&TryExpression{
+				Block:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Param:   &Identifier{Value: "e"},
+				Catch:   &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+				Finally: &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: two()}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		rewritten := Rewrite(tt.input, turnOneIntoTwo)
+
+		if !reflect.DeepEqual(rewritten, tt.expected) {
+			t.Errorf("not equal. got=%#v, want=%#v", rewritten, tt.expected)
+		}
+	}
+
+	hashLiteral := &HashLiteral{
+		Pairs: map[Expression]Expression{
+			one(): one(),
+			one(): one(),
+		},
+	}
+
+	rewritten := Rewrite(hashLiteral, turnOneIntoTwo).(*HashLiteral)
+
+	for key, val := range rewritten.Pairs {
+		key, _ := key.(*IntegerLiteral)
+		if key.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, key.Value)
+		}
+		val, _ := val.(*IntegerLiteral)
+		if val.Value != 2 {
+			t.Errorf("value is not %d, got=%d", 2, val.Value)
+		}
+	}
+}
+
+// the nodes on the way to a replaced one are copies, the tree given is left as it is
+func TestRewriteKeepsInput(t *testing.T) {
+	input := &InfixExpression{Left: &Identifier{Value: "x"}, Operator: "+", Right: &Identifier{Value: "y"}}
+
+	rewritten := Rewrite(input, func(node Node) Node {
+		if ident, ok := node.(*Identifier); ok && ident.Value == "x" {
+			return &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1}
+		}
+		return node
+	})
+
+	if input.String() != "(x + y)" {
+		t.Errorf("input was rewritten. got=%q", input.String())
+	}
+	if rewritten.String() != "(1 + y)" {
+		t.Errorf("wrong rewritten node. got=%q", rewritten.String())
+	}
+
+	// a statement cannot replace an expression
+	kept := Rewrite(input, func(node Node) Node {
+		if _, ok := node.(*Identifier); ok {
+			return &BreakStatement{}
+		}
+		return node
+	})
+	if kept.String() != "(x + y)" {
+		t.Errorf("wrong node. got=%q", kept.String())
+	}
+}
+
+// every token of a node is moved, whatever its type
+func TestAt(t *testing.T) {
+	pos := token.Position{Offset: 4, Line: 1, Column: 5}
+	end := token.Position{Offset: 9, Line: 1, Column: 10}
+	tokenType := reflect.TypeOf(token.Token{})
+
+	for name, sample := range nodeSamples {
+		node := reflect.New(reflect.TypeOf(sample).Elem()).Interface().(Node)
+		moved := reflect.ValueOf(At(node, pos, end)).Elem()
+		given := reflect.ValueOf(node).Elem()
+		for i := 0; i < moved.NumField(); i++ {
+			if moved.Field(i).Type() != tokenType {
+				continue
+			}
+			field := moved.Type().Field(i).Name
+			if tok := moved.Field(i).Interface().(token.Token); tok.Pos != pos || tok.End != end {
+				t.Errorf("At does not move %s.%s", name, field)
+			}
+			// the node given is left as it is
+			if tok := given.Field(i).Interface().(token.Token); tok.Pos.IsValid() {
+				t.Errorf("At changed %s.%s of the node given", name, field)
+			}
+		}
+	}
+}
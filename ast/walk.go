@@ -0,0 +1,192 @@
+package ast
+
+// A Visitor's Visit method is called by Walk for every node. When it returns
+// a visitor w, Walk visits the children of the node with w, then calls w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree below node depth first, parents before their children
+// and the children in the order they were written. The optional parts of a node
+// that are missing, e.g. an else block, are not visited.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		walkStatements(v, node.Statements)
+	case *ExpressionStatement:
+		walkExpression(v, node.Expression)
+	case *BlockStatement:
+		walkStatements(v, node.Statements)
+	case *LetStatement:
+		walkIdentifier(v, node.Name)
+		walkPattern(v, node.Pattern)
+		walkExpression(v, node.Value)
+	case *ReturnStatement:
+		walkExpression(v, node.ReturnValue)
+	case *ThrowStatement:
+		walkExpression(v, node.Value)
+	case *WhileStatement:
+		walkExpression(v, node.Condition)
+		walkBlock(v, node.Body)
+	case *ForStatement:
+		walkStatement(v, node.Init)
+		walkExpression(v, node.Condition)
+		walkStatement(v, node.Post)
+		walkBlock(v, node.Body)
+	case *ForInStatement:
+		walkIdentifier(v, node.Variable)
+		walkExpression(v, node.Iterable)
+		walkBlock(v, node.Body)
+	case *PrefixExpression:
+		walkExpression(v, node.Right)
+	case *InfixExpression:
+		walkExpression(v, node.Left)
+		walkExpression(v, node.Right)
+	case *LogicalExpression:
+		walkExpression(v, node.Left)
+		walkExpression(v, node.Right)
+	case *AssignExpression:
+		walkExpression(v, node.Target)
+		walkExpression(v, node.Value)
+	case *IfExpression:
+		walkExpression(v, node.Condition)
+		walkBlock(v, node.Consequence)
+		walkBlock(v, node.Alternative)
+	case *MatchExpression:
+		walkExpression(v, node.Subject)
+		for _, arm := range node.Arms {
+			if arm != nil {
+				Walk(v, arm)
+			}
+		}
+	case *MatchArm:
+		walkPattern(v, node.Pattern)
+		walkExpression(v, node.Guard)
+		walkStatement(v, node.Body)
+	case *TryExpression:
+		walkBlock(v, node.Block)
+		walkIdentifier(v, node.Param)
+		walkBlock(v, node.Catch)
+		walkBlock(v, node.Finally)
+	case *FunctionLiteral:
+		walkPatterns(v, node.Parameters)
+		walkBlock(v, node.Body)
+	case *MacroLiteral:
+		for _, param := range node.Parameters {
+			walkIdentifier(v, param)
+		}
+		walkBlock(v, node.Body)
+	case *CallExpression:
+		walkExpression(v, node.Function)
+		walkExpressions(v, node.Arguments)
+	case *SpreadExpression:
+		walkExpression(v, node.Value)
+	case *NamedArgument:
+		walkIdentifier(v, node.Name)
+		walkExpression(v, node.Value)
+	case *ArrayLiteral:
+		walkExpressions(v, node.Elements)
+	case *IndexExpression:
+		walkExpression(v, node.Left)
+		walkExpression(v, node.Index)
+	case *HashLiteral:
+		for _, key := range node.Keys() {
+			walkExpression(v, key)
+			walkExpression(v, node.Pairs[key])
+		}
+	case *BindingPattern:
+		walkIdentifier(v, node.Name)
+	case *RestPattern:
+		walkIdentifier(v, node.Name)
+	case *LiteralPattern:
+		walkExpression(v, node.Value)
+	case *DefaultPattern:
+		walkPattern(v, node.Pattern)
+		walkExpression(v, node.Default)
+	case *AlternativePattern:
+		walkPatterns(v, node.Alternatives)
+	case *ArrayPattern:
+		walkPatterns(v, node.Elements)
+	case *HashPattern:
+		// a key is followed by the pattern of its value
+		for i, key := range node.Keys {
+			walkExpression(v, key)
+			if i < len(node.Values) {
+				walkPattern(v, node.Values[i])
+			}
+		}
+	}
+	// identifiers, literals and the placeholders of syntax errors have no children
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree below node like Walk, calling f for every node.
+// The children of a node are skipped when f returns false for it.
+// After the children f is called with nil.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// the interfaces holding no node are skipped, as they would be non-nil Nodes
+
+func walkStatement(v Visitor, stmt Statement) {
+	if stmt != nil {
+		Walk(v, stmt)
+	}
+}
+
+func walkExpression(v Visitor, exp Expression) {
+	if exp != nil {
+		Walk(v, exp)
+	}
+}
+
+func walkPattern(v Visitor, pattern Pattern) {
+	if pattern != nil {
+		Walk(v, pattern)
+	}
+}
+
+func walkBlock(v Visitor, block *BlockStatement) {
+	if block != nil {
+		Walk(v, block)
+	}
+}
+
+func walkIdentifier(v Visitor, ident *Identifier) {
+	if ident != nil {
+		Walk(v, ident)
+	}
+}
+
+func walkStatements(v Visitor, stmts []Statement) {
+	for _, stmt := range stmts {
+		walkStatement(v, stmt)
+	}
+}
+
+func walkExpressions(v Visitor, exps []Expression) {
+	for _, exp := range exps {
+		walkExpression(v, exp)
+	}
+}
+
+func walkPatterns(v Visitor, patterns []Pattern) {
+	for _, pattern := range patterns {
+		walkPattern(v, pattern)
+	}
+}
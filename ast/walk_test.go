@@ -0,0 +1,280 @@
+package ast
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	gotoken "go/token"
+	"lexer-parser/token"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func ident(name string, offset int) *Identifier {
+	return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name, Pos: token.Position{Offset: offset, Line: 1, Column: offset + 1}}, Value: name}
+}
+
+func TestInspect(t *testing.T) {
+	// let f = fn(a) { a + b }; f
+	program := &Program{Statements: []Statement{
+		&LetStatement{Name: ident("f", 4), Value: &FunctionLiteral{
+			Parameters: []Pattern{&BindingPattern{Name: ident("a", 11)}},
+			Body: &BlockStatement{Statements: []Statement{
+				&ExpressionStatement{Expression: &InfixExpression{Left: ident("a", 16), Operator: "+", Right: ident("b", 20)}},
+			}},
+		}},
+		&ExpressionStatement{Expression: ident("f", 25)},
+	}}
+
+	visited := []string{}
+	Inspect(program, func(node Node) bool {
+		if node == nil {
+			visited = append(visited, "end")
+			return true
+		}
+		visited = append(visited, fmt.Sprintf("%T", node))
+		return true
+	})
+
+	expected := []string{
+		"*ast.Program",
+		"*ast.LetStatement", "*ast.Identifier", "end",
+		"*ast.FunctionLiteral",
+		"*ast.BindingPattern", "*ast.Identifier", "end", "end",
+		"*ast.BlockStatement", "*ast.ExpressionStatement",
+		"*ast.InfixExpression", "*ast.Identifier", "end", "*ast.Identifier", "end", "end",
+		"end", "end",
+		"end", "end",
+		"*ast.ExpressionStatement", "*ast.Identifier", "end", "end",
+		"end",
+	}
+	if strings.Join(visited, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong order.\nwant=%v\ngot= %v", expected, visited)
+	}
+
+	// the function is skipped with its parameters and body
+	names := []string{}
+	Inspect(program, func(node Node) bool {
+		switch node := node.(type) {
+		case *FunctionLiteral:
+			return false
+		case *Identifier:
+			names = append(names, node.Value)
+		}
+		return true
+	})
+	if strings.Join(names, " ") != "f f" {
+		t.Errorf("wrong identifiers. got=%v", names)
+	}
+}
+
+func TestWalkHashLiteralInSourceOrder(t *testing.T) {
+	hash := &HashLiteral{Pairs: map[Expression]Expression{
+		ident("c", 9):  ident("d", 12),
+		ident("a", 1):  ident("b", 4),
+		ident("e", 15): ident("f", 18),
+	}}
+
+	names := []string{}
+	Inspect(hash, func(node Node) bool {
+		if ident, ok := node.(*Identifier); ok {
+			names = append(names, ident.Value)
+		}
+		return true
+	})
+	if strings.Join(names, "") != "abcdef" {
+		t.Errorf("wrong order. got=%v", names)
+	}
+}
+
+// every node type declared in this package, found by its Pos method
+func nodeTypeNames(t *testing.T) []string {
+	t.Helper()
+	fset := gotoken.NewFileSet()
+	pkgs, err := goparser.ParseDir(fset, ".", nil, 0)
+	if err != nil {
+		t.Fatalf("cannot parse the package: %s", err)
+	}
+
+	names := []string{}
+	for _, file := range pkgs["ast"].Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*goast.FuncDecl)
+			if !ok || fn.Recv == nil || fn.Name.Name != "Pos" {
+				continue
+			}
+			if star, ok := fn.Recv.List[0].Type.(*goast.StarExpr); ok {
+				names = append(names, star.X.(*goast.Ident).Name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// one node of every type, add new node types here
+var nodeSamples = map[string]Node{
+	"Program":             &Program{},
+	"LetStatement":        &LetStatement{},
+	"Identifier":          &Identifier{},
+	"ReturnStatement":     &ReturnStatement{},
+	"WhileStatement":      &WhileStatement{},
+	"ForStatement":        &ForStatement{},
+	"ForInStatement":      &ForInStatement{},
+	"BreakStatement":      &BreakStatement{},
+	"ContinueStatement":   &ContinueStatement{},
+	"ThrowStatement":      &ThrowStatement{},
+	"ExpressionStatement": &ExpressionStatement{},
+	"BlockStatement":      &BlockStatement{},
+	"IntegerLiteral":      &IntegerLiteral{},
+	"FloatLiteral":        &FloatLiteral{},
+	"FunctionLiteral":     &FunctionLiteral{},
+	"MacroLiteral":        &MacroLiteral{},
+	"PrefixExpression":    &PrefixExpression{},
+	"InfixExpression":     &InfixExpression{},
+	"LogicalExpression":   &LogicalExpression{},
+	"AssignExpression":    &AssignExpression{},
+	"Boolean":             &Boolean{},
+	"IfExpression":        &IfExpression{},
+	"MatchExpression":     &MatchExpression{},
+	"MatchArm":            &MatchArm{},
+	"TryExpression":       &TryExpression{},
+	"WildcardPattern":     &WildcardPattern{},
+	"BindingPattern":      &BindingPattern{},
+	"LiteralPattern":      &LiteralPattern{},
+	"DefaultPattern":      &DefaultPattern{},
+	"RestPattern":         &RestPattern{},
+	"AlternativePattern":  &AlternativePattern{},
+	"ArrayPattern":        &ArrayPattern{},
+	"HashPattern":         &HashPattern{},
+	"CallExpression":      &CallExpression{},
+	"SpreadExpression":    &SpreadExpression{},
+	"NamedArgument":       &NamedArgument{},
+	"StringLiteral":       &StringLiteral{},
+	"ArrayLiteral":        &ArrayLiteral{},
+	"IndexExpression":     &IndexExpression{},
+	"HashLiteral":         &HashLiteral{},
+	"BadStatement":        &BadStatement{},
+	"BadExpression":       &BadExpression{},
+	"Comment":             &Comment{},
+}
+
+var (
+	nodeType       = reflect.TypeOf((*Node)(nil)).Elem()
+	expressionType = reflect.TypeOf((*Expression)(nil)).Elem()
+	statementType  = reflect.TypeOf((*Statement)(nil)).Elem()
+	patternType    = reflect.TypeOf((*Pattern)(nil)).Elem()
+)
+
+// filler sets every child of a node to a tree holding a marker identifier,
+// so the tests can tell which fields Walk and Rewrite reach
+type filler struct {
+	markers map[string]string // marker name -> the field it is in
+	field   string
+}
+
+func (f *filler) marker() *Identifier {
+	name := fmt.Sprintf("m%d", len(f.markers))
+	f.markers[name] = f.field
+	return ident(name, len(f.markers))
+}
+
+func (f *filler) child(typ reflect.Type) (reflect.Value, bool) {
+	switch typ {
+	case reflect.TypeOf(&Identifier{}), expressionType, nodeType:
+		return reflect.ValueOf(f.marker()), true
+	case statementType:
+		return reflect.ValueOf(&ExpressionStatement{Expression: f.marker()}), true
+	case patternType:
+		return reflect.ValueOf(&BindingPattern{Name: f.marker()}), true
+	case reflect.TypeOf(&BlockStatement{}):
+		return reflect.ValueOf(&BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: f.marker()}}}), true
+	case reflect.TypeOf(&MatchArm{}):
+		return reflect.ValueOf(&MatchArm{Pattern: &BindingPattern{Name: f.marker()}}), true
+	}
+
+	switch typ.Kind() {
+	case reflect.Slice:
+		el, ok := f.child(typ.Elem())
+		if !ok {
+			return reflect.Value{}, false
+		}
+		slice := reflect.MakeSlice(typ, 0, 1)
+		return reflect.Append(slice, el), true
+	case reflect.Map:
+		key, ok := f.child(typ.Key())
+		if !ok {
+			return reflect.Value{}, false
+		}
+		value, _ := f.child(typ.Elem())
+		m := reflect.MakeMap(typ)
+		m.SetMapIndex(key, value)
+		return m, true
+	}
+	return reflect.Value{}, false
+}
+
+func holdsNodes(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Slice, reflect.Map:
+		return holdsNodes(typ.Elem())
+	}
+	return typ.Implements(nodeType)
+}
+
+func TestEveryNodeIsWalked(t *testing.T) {
+	for _, name := range nodeTypeNames(t) {
+		sample, ok := nodeSamples[name]
+		if !ok {
+			t.Errorf("no sample of %s, add it to nodeSamples", name)
+			continue
+		}
+
+		node := reflect.New(reflect.TypeOf(sample).Elem())
+		f := &filler{markers: map[string]string{}}
+		for i := 0; i < node.Elem().NumField(); i++ {
+			field := node.Elem().Type().Field(i)
+			if !holdsNodes(field.Type) {
+				continue
+			}
+			f.field = name + "." + field.Name
+			child, ok := f.child(field.Type)
+			if !ok {
+				t.Fatalf("cannot fill %s of type %s", f.field, field.Type)
+			}
+			node.Elem().Field(i).Set(child)
+		}
+
+		walked := map[string]bool{}
+		Inspect(node.Interface().(Node), func(n Node) bool {
+			if ident, ok := n.(*Identifier); ok {
+				walked[ident.Value] = true
+			}
+			return true
+		})
+		rewritten := Rewrite(node.Interface().(Node), func(n Node) Node {
+			if ident, ok := n.(*Identifier); ok && f.markers[ident.Value] != "" {
+				return &Identifier{Token: ident.Token, Value: "new"}
+			}
+			return n
+		})
+		remaining := map[string]bool{}
+		Inspect(rewritten, func(n Node) bool {
+			if ident, ok := n.(*Identifier); ok {
+				remaining[ident.Value] = true
+			}
+			return true
+		})
+
+		for marker, field := range f.markers {
+			if !walked[marker] {
+				t.Errorf("Walk does not visit %s", field)
+			}
+			if remaining[marker] {
+				t.Errorf("Rewrite does not replace %s", field)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Command monkeyfmt formats Monkey programs.
+//
+//	monkeyfmt [-l] [-w] [file ...]
+//
+// Without files it formats its standard input to its standard output.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"lexer-parser/diagnostic"
+	"lexer-parser/format"
+	"os"
+)
+
+var list = flag.Bool("l", false, "list the files whose formatting differs instead of printing them")
+var write = flag.Bool("w", false, "write the result to the files instead of printing it")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: monkeyfmt [-l] [-w] [file ...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "monkeyfmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err == nil {
+			err = formatFile("<stdin>", string(src), os.Stdout)
+		}
+		if err != nil {
+			report("<stdin>", string(src), err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		src, err := os.ReadFile(path)
+		if err == nil {
+			err = formatFile(path, string(src), os.Stdout)
+		}
+		if err != nil {
+			report(path, string(src), err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(2)
+	}
+}
+
+func formatFile(path, src string, out io.Writer) error {
+	formatted, err := format.Source(src)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		if formatted != src {
+			fmt.Fprintln(out, path)
+		}
+		return nil
+	}
+	if *write {
+		if formatted == src {
+			return nil
+		}
+		return os.WriteFile(path, []byte(formatted), 0644)
+	}
+	_, err = io.WriteString(out, formatted)
+	return err
+}
+
+// syntax errors are shown with the source they are in
+func report(path, src string, err error) {
+	var syntaxErr *format.Error
+	if errors.As(err, &syntaxErr) {
+		fmt.Fprintf(os.Stderr, "%s:\n%s", path, diagnostic.RenderAll(src, syntaxErr.Diagnostics))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "monkeyfmt: %s\n", err)
+}
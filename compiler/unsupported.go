@@ -0,0 +1,50 @@
+package compiler
+
+import (
+	"fmt"
+	"lexer-parser/ast"
+	"lexer-parser/diagnostic"
+)
+
+// Unsupported reports every part of node the vm cannot run yet, e.g. loops, assignments,
+// match or try, so a program can be refused before any of it runs
+func Unsupported(node ast.Node) []*diagnostic.Diagnostic {
+	var diagnostics []*diagnostic.Diagnostic
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+		if what := unsupported(n); what != "" {
+			d := diagnostic.NewSpan(diagnostic.NotSupportedByVM, n.Pos(), n.End(), "%s is not supported by the vm", what)
+			diagnostics = append(diagnostics, d.WithHint("run the program with the eval engine"))
+			return false
+		}
+		return true
+	})
+	return diagnostics
+}
+
+// what node is when the compiler has no instructions for it, "" when it has
+func unsupported(node ast.Node) string {
+	switch node := node.(type) {
+	case *ast.Program, *ast.ExpressionStatement, *ast.BlockStatement, *ast.ReturnStatement,
+		*ast.InfixExpression, *ast.LogicalExpression, *ast.PrefixExpression, *ast.IfExpression,
+		*ast.Identifier, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean,
+		*ast.ArrayLiteral, *ast.HashLiteral, *ast.IndexExpression, *ast.CallExpression,
+		*ast.BindingPattern, *ast.BadStatement, *ast.BadExpression:
+		return ""
+	case *ast.LetStatement:
+		if node.Pattern != nil {
+			return "destructuring"
+		}
+		return ""
+	case *ast.FunctionLiteral:
+		for _, p := range node.Parameters {
+			if _, ok := p.(*ast.BindingPattern); !ok {
+				return "parameter " + p.String()
+			}
+		}
+		return ""
+	}
+	return fmt.Sprintf("%T", node)
+}
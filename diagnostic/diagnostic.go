@@ -0,0 +1,187 @@
+package diagnostic
+
+import (
+	"bytes"
+	"fmt"
+	"lexer-parser/token"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity tells how serious a diagnostic is
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Info
+)
+
+var severityNames = map[Severity]string{
+	Error:   "error",
+	Warning: "warning",
+	Info:    "info",
+}
+
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// severities are written as their names, so editors don't depend on the numbering
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	for severity, name := range severityNames {
+		if name == string(text) {
+			*s = severity
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q", text)
+}
+
+// Stable codes identifying each kind of diagnostic
+const (
+	UnexpectedToken = "P001" // expected one token but got another
+	NoPrefixParseFn = "P002" // a token that cannot start an expression
+	InvalidInteger  = "P003" // an integer literal out of range
+	InvalidFloat    = "P004" // a float literal out of range
+	OutsideLoop     = "P005" // break or continue outside of a loop
+	InvalidTarget   = "P006" // assignment to something else than a variable or an index
+	InvalidPattern  = "P007" // a token that cannot be part of a pattern
+	InvalidArgument = "P008" // a positional argument following a named one
+	InvalidString   = "P009" // a string or a block comment left open, or an unknown escape sequence
+
+	AssignToConstant   = "R001" // assignment to a const binding
+	RedeclaredConstant = "R002" // let or const reusing the name of a const in the same scope
+	DuplicateBinding   = "R003" // a name bound twice by one pattern or parameter list
+	AlternativeNames   = "R004" // alternatives of a pattern binding different names
+
+	NotSupportedByVM = "V001" // a feature the bytecode vm cannot run yet
+)
+
+// A Diagnostic is a problem found in the source, e.g. a syntax error.
+// It keeps enough information to be rendered for humans or consumed by tools.
+type Diagnostic struct {
+	Severity Severity          `json:"severity"`
+	Code     string            `json:"code"`
+	Message  string            `json:"message"`
+	Pos      token.Position    `json:"pos"` // the first character of the offending source
+	End      token.Position    `json:"end"` // immediately after the offending source
+	Expected []token.TokenType `json:"expected,omitempty"`
+	Actual   token.TokenType   `json:"actual,omitempty"`
+	Hints    []string          `json:"hints,omitempty"`
+}
+
+// New creates an error diagnostic spanning the given token
+func New(code string, tok token.Token, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Actual:   tok.Type,
+	}
+}
+
+// NewSpan creates an error diagnostic spanning the source from pos to end, e.g. a whole node
+func NewSpan(code string, pos, end token.Position, format string, a ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      pos,
+		End:      end,
+	}
+}
+
+// WithHint appends a suggestion on how to fix the problem
+func (d *Diagnostic) WithHint(format string, a ...interface{}) *Diagnostic {
+	d.Hints = append(d.Hints, fmt.Sprintf(format, a...))
+	return d
+}
+
+// Error keeps diagnostics usable wherever a plain message is expected
+func (d *Diagnostic) Error() string { return d.Message }
+
+// String is the one-line form `line:column: severity[code]: message`
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Pos, d.Severity, d.Code, d.Message)
+}
+
+// Render prints the diagnostic with the offending line of source underlined
+//
+//	error[P001]: expected next token to be ), got ; instead
+//	 --> 1:10
+//	  |
+//	1 | add(1, 2 ;
+//	  |          ^
+//	  = hint: ...
+func (d *Diagnostic) Render(source string) string {
+	var out bytes.Buffer
+
+	out.WriteString(fmt.Sprintf("%s[%s]: %s\n", d.Severity, d.Code, d.Message))
+
+	line, ok := sourceLine(source, d.Pos.Line)
+	if !d.Pos.IsValid() || !ok {
+		for _, hint := range d.Hints {
+			out.WriteString("  = hint: " + hint + "\n")
+		}
+		return out.String()
+	}
+
+	number := fmt.Sprintf("%d", d.Pos.Line)
+	gutter := strings.Repeat(" ", len(number))
+
+	out.WriteString(fmt.Sprintf("%s--> %s\n", gutter, d.Pos))
+	out.WriteString(gutter + " |\n")
+	out.WriteString(number + " | " + expandTabs(line) + "\n")
+	out.WriteString(gutter + " | " + underline(line, d.Pos, d.End) + "\n")
+	for _, hint := range d.Hints {
+		out.WriteString(gutter + " = hint: " + hint + "\n")
+	}
+
+	return out.String()
+}
+
+// RenderAll renders every diagnostic, one after the other
+func RenderAll(source string, diagnostics []*Diagnostic) string {
+	var out bytes.Buffer
+	for _, d := range diagnostics {
+		out.WriteString(d.Render(source))
+	}
+	return out.String()
+}
+
+// the n-th line (starting at 1) of source, without its line break
+func sourceLine(source string, n int) (string, bool) {
+	lines := strings.Split(source, "\n")
+	if n < 1 || n > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[n-1], "\r"), true
+}
+
+// tabs are printed as single spaces so the caret line stays aligned
+func expandTabs(line string) string {
+	return strings.ReplaceAll(line, "\t", " ")
+}
+
+// builds the `   ^^^` marker below the line, at least one caret wide
+func underline(line string, pos, end token.Position) string {
+	start := pos.Column - 1
+	if n := utf8.RuneCountInString(line); start > n {
+		start = n
+	}
+	width := 1
+	if end.Line == pos.Line && end.Column > pos.Column {
+		width = end.Column - pos.Column
+	}
+	return strings.Repeat(" ", start) + strings.Repeat("^", width)
+}
@@ -0,0 +1,90 @@
+package diagnostic
+
+import (
+	"encoding/json"
+	"lexer-parser/token"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	source := "let x = 1;\nlet y = add(x, 2 ;"
+	d := New(UnexpectedToken, token.Token{
+		Type:    token.SEMICOLON,
+		Literal: ";",
+		Pos:     token.Position{Offset: 28, Line: 2, Column: 18},
+		End:     token.Position{Offset: 29, Line: 2, Column: 19},
+	}, "expected next token to be %s, got %s instead", token.RPAREN, token.SEMICOLON)
+	d.WithHint("check for a missing )")
+
+	expected := `error[P001]: expected next token to be ), got ; instead
+ --> 2:18
+  |
+2 | let y = add(x, 2 ;
+  |                  ^
+  = hint: check for a missing )
+`
+	if actual := d.Render(source); actual != expected {
+		t.Errorf("wrong rendering.\nexpected=\n%s\ngot=\n%s", expected, actual)
+	}
+
+	if d.String() != "2:18: error[P001]: expected next token to be ), got ; instead" {
+		t.Errorf("wrong one-line form. got=%q", d.String())
+	}
+}
+
+func TestRenderMultibyte(t *testing.T) {
+	source := `let s = "héllo" + ;`
+	d := New(NoPrefixParseFn, token.Token{
+		Type:    token.SEMICOLON,
+		Literal: ";",
+		Pos:     token.Position{Offset: 19, Line: 1, Column: 19},
+		End:     token.Position{Offset: 20, Line: 1, Column: 20},
+	}, "no prefix parse function for ; found")
+
+	expected := `error[P002]: no prefix parse function for ; found
+ --> 1:19
+  |
+1 | let s = "héllo" + ;
+  |                   ^
+`
+	if actual := d.Render(source); actual != expected {
+		t.Errorf("wrong rendering.\nexpected=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestRenderWithoutPosition(t *testing.T) {
+	d := &Diagnostic{Severity: Warning, Code: "X000", Message: "somewhere"}
+
+	expected := "warning[X000]: somewhere\n"
+	if actual := d.Render("let x = 1;"); actual != expected {
+		t.Errorf("wrong rendering. expected=%q, got=%q", expected, actual)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	d := New(NoPrefixParseFn, token.Token{
+		Type:    token.RPAREN,
+		Literal: ")",
+		Pos:     token.Position{Offset: 0, Line: 1, Column: 1},
+		End:     token.Position{Offset: 1, Line: 1, Column: 2},
+	}, "no prefix parse function for %s found", token.RPAREN)
+
+	encoded, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %s", err)
+	}
+
+	expected := `{"severity":"error","code":"P002","message":"no prefix parse function for ) found",` +
+		`"pos":{"offset":0,"line":1,"column":1},"end":{"offset":1,"line":1,"column":2},"actual":")"}`
+	if string(encoded) != expected {
+		t.Errorf("wrong json.\nexpected=%s\ngot=     %s", expected, encoded)
+	}
+
+	var decoded Diagnostic
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s", err)
+	}
+	if decoded.Severity != Error || decoded.Pos != d.Pos {
+		t.Errorf("diagnostic did not survive a round trip. got=%+v", decoded)
+	}
+}
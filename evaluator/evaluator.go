@@ -1,9 +1,13 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
 	"lexer-parser/ast"
 	"lexer-parser/object"
+	"math"
+	"math/big"
+	"strings"
 )
 
 // convert literal into object enum
@@ -11,11 +15,25 @@ var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 // error handle
 func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return newKindError(object.GenericError, format, a...)
+}
+
+func newKindError(kind object.ErrorKind, format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: kind}
+}
+
+// an error stopping the program, no try catches it
+func newFatalError(format string, a ...interface{}) *object.Error {
+	err := newError(format, a...)
+	err.Fatal = true
+	return err
 }
 
 // judge whether is a Error Object
@@ -26,107 +44,347 @@ func isError(obj object.Object) bool {
 	return false
 }
 
-// Eval recursively and call itself while evaluating a part of the AST
+// Limits bounds the work a program may do
+type Limits struct {
+	MaxSteps int64 // the number of AST nodes evaluated, zero means no limit
+	MaxDepth int   // the number of nested function calls, DefaultMaxDepth when zero
+
+	// Strict makes destructuring fail when an element or a key is missing, instead of binding null
+	Strict bool
+}
+
+// deep enough for the recursive helpers of the REPL, far below what the Go stack can take
+const DefaultMaxDepth = 10000
+
+// how many frames a stack overflow error shows
+const overflowFrames = 5
+
+// the state of one evaluation, shared by every function call of the program
+type interpreter struct {
+	ctx    context.Context
+	limits Limits
+	steps  int64
+	frames []object.Frame // the function calls being evaluated
+}
+
+// how many steps run between two looks at the context
+const cancelCheckInterval = 1024
+
+// Eval evaluates node without any limit, see EvalContext
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return EvalContext(context.Background(), node, env, Limits{})
+}
+
+// EvalContext evaluates node until it is done, ctx is cancelled or the limits are exceeded.
+// The last two stop the program with an error: "execution cancelled" or "step limit exceeded".
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits Limits) object.Object {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	in := &interpreter{ctx: ctx, limits: limits}
+	return in.eval(node, env)
+}
+
+// count one step, returns an error when the program has to stop
+func (in *interpreter) tick() *object.Error {
+	in.steps++
+	if in.limits.MaxSteps > 0 && in.steps > in.limits.MaxSteps {
+		return newFatalError("step limit exceeded")
+	}
+	if in.steps%cancelCheckInterval == 0 {
+		return in.checkCancelled()
+	}
+	return nil
+}
+
+func (in *interpreter) checkCancelled() *object.Error {
+	if in.ctx.Err() != nil {
+		return newFatalError("execution cancelled")
+	}
+	return nil
+}
+
+// enter a function call, fails when the calls are nested too deep
+func (in *interpreter) pushFrame(f object.Frame) *object.Error {
+	if len(in.frames) >= in.limits.MaxDepth {
+		return in.stackOverflow(f)
+	}
+	in.frames = append(in.frames, f)
+	return nil
+}
+
+// leave a function call with its result, an error leaving it records the call in its stack.
+// A stack overflow names the most recent calls already, its stack would be thousands of them
+func (in *interpreter) leaveFrame(result object.Object) object.Object {
+	if err, ok := result.(*object.Error); ok && !err.Fatal && err.Kind != object.RecursionError {
+		err.Stack = append(err.Stack, in.frames[len(in.frames)-1])
+	}
+	in.frames = in.frames[:len(in.frames)-1]
+	return result
+}
+
+// the error shows the depth and the most recent calls, the one that failed first.
+// Unlike running out of steps it can be caught, the calls are left on the way to the try
+func (in *interpreter) stackOverflow(f object.Frame) *object.Error {
+	recent := []string{f.String()}
+	for i := len(in.frames) - 1; i >= 0 && len(recent) < overflowFrames; i-- {
+		recent = append(recent, in.frames[i].String())
+	}
+	return newKindError(object.RecursionError, "stack overflow: maximum call depth of %d exceeded, most recent calls: %s",
+		in.limits.MaxDepth, strings.Join(recent, ", "))
+}
+
+// eval recursively and call itself while evaluating a part of the AST.
+// An error gets the position of the innermost node it comes from
+func (in *interpreter) eval(node ast.Node, env *object.Environment) object.Object {
+	result := in.evalNode(node, env)
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() && err != errNoMatch {
+		err.Pos = node.Pos()
+	}
+	return result
+}
+
+func (in *interpreter) evalNode(node ast.Node, env *object.Environment) object.Object {
+	if err := in.tick(); err != nil {
+		return err
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		// Statements
-		return evalProgram(node, env)
+		return in.evalProgram(node, env)
 	case *ast.ExpressionStatement:
 		// Expressions
-		return Eval(node.Expression, env)
+		return in.eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		// Integer literal
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		// Boolean literal
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		// Prefix Expression
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := in.eval(node.Right, env)
+		if stopsBlock(right) {
 			return right
 		}
 		return evalPrefixExpressions(node.Operator, right)
 	case *ast.InfixExpression:
 		// Infix Expression
-		left := Eval(node.Left, env)
-		right := Eval(node.Right, env)
-		if isError(left) {
+		left := in.eval(node.Left, env)
+		if stopsBlock(left) {
 			return left
 		}
-		if isError(right) {
+		right := in.eval(node.Right, env)
+		if stopsBlock(right) {
 			return right
 		}
 		return evalInfixExpression(node.Operator, left, right)
+	case *ast.LogicalExpression:
+		return in.evalLogicalExpression(node, env)
+	case *ast.AssignExpression:
+		return in.evalAssignExpression(node, env)
+	case *ast.MatchExpression:
+		return in.evalMatchExpression(node, env)
+	case *ast.TryExpression:
+		return in.evalTryExpression(node, env)
 	case *ast.BlockStatement:
 		// Block Statement
-		return evalBlockStatement(node, env)
+		return in.evalBlockStatement(node, env)
 	case *ast.IfExpression:
 		// If - else expression
-		return evalIfExpression(node, env)
+		return in.evalIfExpression(node, env)
 	case *ast.ReturnStatement:
 		// return <statement>;
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		// inside a function the returned expression is in tail position
+		var val object.Object
+		if len(in.frames) > 0 {
+			val = in.evalTail(node.ReturnValue, env)
+		} else {
+			val = in.eval(node.ReturnValue, env)
+		}
+		if stopsBlock(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.WhileStatement:
+		return in.evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return in.evalForStatement(node, env)
+	case *ast.ForInStatement:
+		return in.evalForInStatement(node, env)
+	case *ast.ThrowStatement:
+		return in.evalThrowStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
 	case *ast.LetStatement:
-		// let <literal> = <expression>;
-		val := Eval(node.Value, env)
-		if isError(val) {
+		// let <literal> = <expression>; or const <literal> = <expression>;
+		val := in.eval(node.Value, env)
+		if stopsBlock(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			return in.evalDestructuring(node, val, env)
+		}
 		// keep track of values
-		env.Set(node.Name.Value, val)
+		if err := env.Define(node.Name.Value, val, node.IsConst()); err != nil {
+			return newKindError(object.NameError, "cannot redeclare constant: %s", node.Name.Value)
+		}
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
+	case *ast.MacroLiteral:
+		// the macros are taken out of the program by DefineMacros before it runs
+		return newError("macros can only be defined by a let at the top of a program")
 	case *ast.FunctionLiteral:
 		// Function Literal
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Env: env, Body: body, Name: node.Name}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
+		if isQuoteCall(node) {
+			return in.quote(node, env)
+		}
+		function := in.eval(node.Function, env)
+		if stopsBlock(function) {
 			return function
 		}
-		args := evalExpression(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := in.evalExpression(node.Arguments, env)
+		if len(args) == 1 && stopsBlock(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return in.applyFunction(node, function, args)
+	case *ast.NamedArgument:
+		val := in.eval(node.Value, env)
+		if stopsBlock(val) {
+			return val
+		}
+		return &namedArgument{name: node.Name.Value, value: val}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 
 	case *ast.ArrayLiteral:
-		elements := evalExpression(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		elements := in.evalExpression(node.Elements, env)
+		if len(elements) == 1 && stopsBlock(elements[0]) {
 			return elements[0]
 		}
 		return &object.Array{Elements: elements}
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := in.eval(node.Left, env)
+		if stopsBlock(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
-		if isError(index) {
+		index := in.eval(node.Index, env)
+		if stopsBlock(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return in.evalHashLiteral(node, env)
+	case *ast.BadStatement, *ast.BadExpression:
+		// placeholders left by the parser, the program should not have been evaluated
+		return newError("syntax error at %s", node.Pos())
 	}
 	return nil
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
+// a call in tail position, applyFunction runs it in place of the function returning it
+type tailCall struct {
+	call *ast.CallExpression
+	fn   *object.Function
+	args []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call " + tc.call.String() }
+
+// evalTail evaluates a node whose value is the result of the function being called.
+// Calls of Monkey functions found there are not applied but returned as a *tailCall,
+// so the trampoline in applyFunction runs them without growing the Go stack.
+// One returned from inside an expression gets there too: the expressions around
+// a return value stop like they do for an error, none of them keeps it as a value.
+func (in *interpreter) evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		if err := in.tick(); err != nil {
+			return err
+		}
+		var result object.Object
+		for i, statement := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return in.evalTail(statement, env)
+			}
+			result = in.eval(statement, env)
+
+			if stopsBlock(result) {
+				return result
+			}
+		}
+		return result
+	case *ast.ExpressionStatement:
+		if err := in.tick(); err != nil {
+			return err
+		}
+		return in.evalTail(node.Expression, env)
+	case *ast.IfExpression:
+		if err := in.tick(); err != nil {
+			return err
+		}
+		condition := in.eval(node.Condition, env)
+		if stopsBlock(condition) {
+			return condition
+		}
+		if isTruthy(condition) {
+			return in.evalTail(node.Consequence, env)
+		} else if node.Alternative != nil {
+			return in.evalTail(node.Alternative, env)
+		}
+		return NULL
+	case *ast.MatchExpression:
+		if err := in.tick(); err != nil {
+			return err
+		}
+		arm, armEnv, err := in.selectMatchArm(node, env)
+		if err != nil {
+			return err
+		}
+		return in.evalTail(arm.Body, armEnv)
+	case *ast.CallExpression:
+		// quote is no function, there is nothing to call
+		if isQuoteCall(node) {
+			return in.eval(node, env)
+		}
+		if err := in.tick(); err != nil {
+			return err
+		}
+		function := in.eval(node.Function, env)
+		if stopsBlock(function) {
+			return function
+		}
+		args := in.evalExpression(node.Arguments, env)
+		if len(args) == 1 && stopsBlock(args[0]) {
+			return args[0]
+		}
+		if fn, ok := function.(*object.Function); ok {
+			return &tailCall{call: node, fn: fn, args: args}
+		}
+		return in.applyFunction(node, function, args)
+	default:
+		return in.eval(node, env)
+	}
+}
+
+func (in *interpreter) evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		result = in.eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -139,11 +397,11 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	return result
 }
 
-func evalStatement(stmts []ast.Statement, env *object.Environment) object.Object {
+func (in *interpreter) evalStatement(stmts []ast.Statement, env *object.Environment) object.Object {
 	var result object.Object
 
 	for _, statement := range stmts {
-		result = Eval(statement, env)
+		result = in.eval(statement, env)
 
 		if returnValue, ok := result.(*object.ReturnValue); ok {
 			return returnValue.Value
@@ -169,7 +427,7 @@ func evalPrefixExpressions(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newKindError(object.TypeError, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -178,39 +436,118 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isInteger(left) && isInteger(right):
+		return evalBigIntInfixExpression(operator, left, right)
+	// an integer meeting a float is promoted to a float
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
 	// {"(1 > 2) == false", true}
-	// {"(1 < 2) != false", true}
+	// {"[1, "a"] == [1, "a"]", true}
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(object.Equals(left, right))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!object.Equals(left, right))
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TypeError, "type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// && and || short-circuit and always give a boolean
+//
+// {"false && x", false}, x is not evaluated
+func (in *interpreter) evalLogicalExpression(le *ast.LogicalExpression, env *object.Environment) object.Object {
+	left := in.eval(le.Left, env)
+	if stopsBlock(left) {
+		return left
+	}
+
+	switch le.Operator {
+	case "&&":
+		if !isTruthy(left) {
+			return FALSE
+		}
+	case "||":
+		if isTruthy(left) {
+			return TRUE
+		}
+	default:
+		return newKindError(object.TypeError, "unknown operator: %s", le.Operator)
+	}
+
+	right := in.eval(le.Right, env)
+	if stopsBlock(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 // {"if (1 > 2) { 10 }", nil},
 //
 // {"if (1 > 2) { 10 } else { 20 }", 20},
-func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+func (in *interpreter) evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
+	condition := in.eval(ie.Condition, env)
+	if stopsBlock(condition) {
 		return condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return in.eval(ie.Consequence, env)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return in.eval(ie.Alternative, env)
 	} else {
 		return NULL
 	}
 }
 
+func (in *interpreter) evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	arm, armEnv, err := in.selectMatchArm(me, env)
+	if err != nil {
+		return err
+	}
+	return in.eval(arm.Body, armEnv)
+}
+
+// the first arm whose pattern matches the subject and whose guard holds, with the environment
+// of its body binding the names of the pattern. No such arm is an error, like any error of the
+// subject or of a guard.
+func (in *interpreter) selectMatchArm(me *ast.MatchExpression, env *object.Environment) (*ast.MatchArm, *object.Environment, object.Object) {
+	subject := in.eval(me.Subject, env)
+	if stopsBlock(subject) {
+		return nil, nil, subject
+	}
+
+	for _, arm := range me.Arms {
+		bindings := make(map[string]object.Object)
+		if err := in.bindPattern(arm.Pattern, subject, env, bindings, matchMode); err == errNoMatch {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		armEnv := object.NewEnclosedEnvironment(env)
+		for name, value := range bindings {
+			armEnv.Set(name, value)
+		}
+
+		if arm.Guard != nil {
+			guard := in.eval(arm.Guard, armEnv)
+			if stopsBlock(guard) {
+				return nil, nil, guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return arm, armEnv, nil
+	}
+
+	return nil, nil, newKindError(object.MatchError, "non-exhaustive match: no arm matches %s", subject.Inspect())
+}
+
 // Eval for the (!)<integer, boolean or other expressions>
 func evalBangOperatorExpression(right object.Object) object.Object {
 	switch right {
@@ -227,11 +564,20 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 
 // Eval Prefix Minus  (-)<integer, boolean or other expressions>
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+	switch right := right.(type) {
+	case *object.Integer:
+		// -9223372036854775808 has no positive int64
+		if right.Value == math.MinInt64 {
+			return object.NewInteger(new(big.Int).Neg(object.ToBig(right)))
+		}
+		return &object.Integer{Value: -right.Value}
+	case *object.BigInt:
+		return object.NewInteger(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
+		return newKindError(object.TypeError, "unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 // Eval Integer Infix Expression calculation
@@ -239,42 +585,316 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
+	// results out of the int64 range are computed again with big integers
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		sum := leftVal + rightVal
+		if (leftVal > 0 && rightVal > 0 && sum < 0) || (leftVal < 0 && rightVal < 0 && sum >= 0) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: sum}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		diff := leftVal - rightVal
+		if (rightVal < 0 && diff < leftVal) || (rightVal > 0 && diff > leftVal) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: diff}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		product := leftVal * rightVal
+		if leftVal != 0 && (product/leftVal != rightVal || (leftVal == -1 && rightVal == math.MinInt64)) {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: product}
 	case "/":
+		if rightVal == 0 {
+			return newKindError(object.ArithmeticError, "division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		// truncated: the result has the sign of the dividend, -7 % 3 == -1
+		if rightVal == 0 {
+			return newKindError(object.ArithmeticError, "modulo by zero")
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newKindError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// an Integer or a BigInt
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+}
+
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+// the value of a number as a float
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	default:
+		return obj.(*object.Float).Value
+	}
+}
+
+// Eval Infix Expression on integers too large for an int64,
+// the result is demoted to an Integer when it fits again
+func evalBigIntInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := object.ToBig(left)
+	rightVal := object.ToBig(right)
+
+	switch operator {
+	case "+":
+		return object.NewInteger(new(big.Int).Add(leftVal, rightVal))
+	case "-":
+		return object.NewInteger(new(big.Int).Sub(leftVal, rightVal))
+	case "*":
+		return object.NewInteger(new(big.Int).Mul(leftVal, rightVal))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newKindError(object.ArithmeticError, "division by zero")
+		}
+		// Quo truncates like the division of int64
+		return object.NewInteger(new(big.Int).Quo(leftVal, rightVal))
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newKindError(object.ArithmeticError, "modulo by zero")
+		}
+		// Rem has the sign of the dividend like the remainder of int64
+		return object.NewInteger(new(big.Int).Rem(leftVal, rightVal))
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newKindError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// Eval Float Infix Expression calculation, either side may be an integer
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		if rightVal == 0 {
+			return newKindError(object.ArithmeticError, "division by zero")
+		}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newKindError(object.ArithmeticError, "modulo by zero")
+		}
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
-func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
+func (in *interpreter) evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = in.eval(statement, env)
+
+		if stopsBlock(result) {
+			return result
+		}
+	}
+
+	return result
+}
 
-		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+// return, errors, break and continue leave the rest of a block unevaluated,
+// and the rest of an expression, e.g. the other elements of an array, too
+func stopsBlock(result object.Object) bool {
+	if result == nil {
+		return false
+	}
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	default:
+		return false
+	}
+}
+
+// Loops run in the environment around them, like the blocks of if,
+// so `let` in the body updates the bindings seen after the loop.
+// A loop has no value, unless a return or an error stops the function.
+
+// while (<condition>) { <body> }
+func (in *interpreter) evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := in.eval(ws.Condition, env)
+		if stopsBlock(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return nil
+		}
+
+		if result, ok := in.evalLoopBody(ws.Body, env); !ok {
+			return result
+		}
+	}
+}
+
+// for (<init>; <condition>; <post>) { <body> }, continue goes on with <post>
+func (in *interpreter) evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	if fs.Init != nil {
+		if init := in.eval(fs.Init, env); stopsBlock(init) {
+			return init
+		}
+	}
+
+	for {
+		if fs.Condition != nil {
+			condition := in.eval(fs.Condition, env)
+			if stopsBlock(condition) {
+				return condition
+			}
+			if !isTruthy(condition) {
+				return nil
+			}
+		}
+
+		if result, ok := in.evalLoopBody(fs.Body, env); !ok {
+			return result
+		}
+
+		if fs.Post != nil {
+			if post := in.eval(fs.Post, env); stopsBlock(post) {
+				return post
+			}
+		}
+	}
+}
+
+// for (<variable> in <iterable>) { <body> }
+//
+// arrays give their elements, hashes their keys in the order of SortedPairs,
+// strings their characters and ranges their integers
+func (in *interpreter) evalForInStatement(fs *ast.ForInStatement, env *object.Environment) object.Object {
+	iterable := in.eval(fs.Iterable, env)
+	if stopsBlock(iterable) {
+		return iterable
+	}
+
+	// run the body with name bound to value, reports whether the loop goes on
+	var result object.Object
+	next := func(value object.Object) bool {
+		if err := env.Define(fs.Variable.Value, value, false); err != nil {
+			result = newKindError(object.NameError, "cannot redeclare constant: %s", fs.Variable.Value)
+			return false
+		}
+		var ok bool
+		result, ok = in.evalLoopBody(fs.Body, env)
+		return ok
+	}
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		// elements pushed while looping are not visited
+		elements := iterable.Elements
+		for _, el := range elements {
+			if !next(el) {
+				return result
+			}
+		}
+	case *object.Hash:
+		for _, pair := range iterable.SortedPairs() {
+			if !next(pair.Key) {
 				return result
 			}
 		}
+	case *object.String:
+		for _, ch := range iterable.Value {
+			if !next(&object.String{Value: string(ch)}) {
+				return result
+			}
+		}
+	case *object.Range:
+		for i := iterable.Start; iterable.Step > 0 && i < iterable.End || iterable.Step < 0 && i > iterable.End; i += iterable.Step {
+			if !next(&object.Integer{Value: i}) {
+				return result
+			}
+			// the next step would go past the end, and maybe past the int64 ones
+			if iterable.Step > 0 && i > iterable.End-iterable.Step || iterable.Step < 0 && i < iterable.End-iterable.Step {
+				break
+			}
+		}
+	default:
+		return newKindError(object.TypeError, "cannot iterate over %s", iterable.Type())
 	}
+	return nil
+}
 
-	return result
+// run one iteration of a loop, reports whether the loop goes on.
+// When it does not, the result is what stops the function: a return value or an error.
+func (in *interpreter) evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	if err := in.checkCancelled(); err != nil {
+		return err, false
+	}
+
+	result := in.eval(body, env)
+	switch result {
+	case BREAK:
+		return nil, false
+	case CONTINUE:
+		return nil, true
+	}
+	if stopsBlock(result) {
+		return result, false
+	}
+	return nil, true
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
@@ -285,15 +905,113 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newKindError(object.NameError, "identifier not found: %s", node.Value)
+}
+
+// x = v updates the binding of x where it was defined, a[i] = v and h[k] = v
+// change the array or the hash in place. The value of the assignment is v.
+func (in *interpreter) evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIdentifier(target, env)
+			if stopsBlock(current) {
+				return current
+			}
+		}
+
+		val := in.evalAssignedValue(node, current, env)
+		if stopsBlock(val) {
+			return val
+		}
+		switch err := env.Assign(target.Value, val); err {
+		case object.ErrUndeclared:
+			return newKindError(object.NameError, "cannot assign to undeclared identifier: %s", target.Value)
+		case object.ErrConstant:
+			return newKindError(object.NameError, "cannot assign to constant: %s", target.Value)
+		}
+		return val
+	case *ast.IndexExpression:
+		left := in.eval(target.Left, env)
+		if stopsBlock(left) {
+			return left
+		}
+		index := in.eval(target.Index, env)
+		if stopsBlock(index) {
+			return index
+		}
+
+		var current object.Object
+		if node.Operator != "=" {
+			current = evalIndexExpression(left, index)
+			if stopsBlock(current) {
+				return current
+			}
+		}
+
+		val := in.evalAssignedValue(node, current, env)
+		if stopsBlock(val) {
+			return val
+		}
+		return evalIndexAssignment(left, index, val)
+	default:
+		return newKindError(object.TypeError, "cannot assign to %s", node.Target.String())
+	}
+}
+
+// the right side of an assignment, combined with the current value for e.g. +=
+func (in *interpreter) evalAssignedValue(node *ast.AssignExpression, current object.Object, env *object.Environment) object.Object {
+	val := in.eval(node.Value, env)
+	if stopsBlock(val) || current == nil {
+		return val
+	}
+	return evalInfixExpression(strings.TrimSuffix(node.Operator, "="), current, val)
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newKindError(object.TypeError, "array index must be INTEGER, got %s", index.Type())
+		}
+		if idx.Value < 0 || idx.Value >= int64(len(left.Elements)) {
+			return newKindError(object.IndexError, "index out of range: %d, length %d", idx.Value, len(left.Elements))
+		}
+		left.Elements[idx.Value] = val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newKindError(object.TypeError, "unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+	default:
+		return newKindError(object.TypeError, "index assignment not supported: %s", left.Type())
+	}
+	return val
 }
 
-func evalExpression(exps []ast.Expression, env *object.Environment) []object.Object {
+// the elements of an array literal or the arguments of a call, spreading ...<expression>
+func (in *interpreter) evalExpression(exps []ast.Expression, env *object.Environment) []object.Object {
 	var result []object.Object
 
 	for _, e := range exps {
-		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if spread, ok := e.(*ast.SpreadExpression); ok {
+			value := in.eval(spread.Value, env)
+			if stopsBlock(value) {
+				return []object.Object{value}
+			}
+			array, ok := value.(*object.Array)
+			if !ok {
+				return []object.Object{newKindError(object.TypeError, "cannot spread %s, want ARRAY", value.Type())}
+			}
+			result = append(result, array.Elements...)
+			continue
+		}
+
+		evaluated := in.eval(e, env)
+		if stopsBlock(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -301,13 +1019,25 @@ func evalExpression(exps []ast.Expression, env *object.Environment) []object.Obj
 	return result
 }
 
+// strings are ordered byte by byte, "B" < "a"
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	default:
+		return newKindError(object.TypeError, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
@@ -317,7 +1047,7 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newKindError(object.TypeError, "index operator not supported: %s", left.Type())
 	}
 }
 
@@ -333,22 +1063,22 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+func (in *interpreter) evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
 	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
-		if isError(key) {
+		key := in.eval(keyNode, env)
+		if stopsBlock(key) {
 			return key
 		}
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newKindError(object.TypeError, "unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := in.eval(valueNode, env)
+		if stopsBlock(value) {
 			return value
 		}
 		hashed := hashKey.HashKey()
@@ -364,7 +1094,7 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newKindError(object.TypeError, "unusable as hash key: %s", index.Type())
 	}
 
 	pair, ok := hashObject.Pairs[key.HashKey()]
@@ -388,28 +1118,203 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+func (in *interpreter) applyFunction(call *ast.CallExpression, fn object.Object, args []object.Object) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		// every call looks at the context, deep recursion is the usual runaway program
+		if err := in.checkCancelled(); err != nil {
+			return err
+		}
+		if err := in.pushFrame(object.Frame{Function: functionName(fn), Pos: call.Pos(), Args: args}); err != nil {
+			return err
+		}
+		// the trampoline: a tail call replaces the current one instead of nesting
+		for {
+			extendedEnv, err := in.extendFunctionEnv(fn, args)
+			if err != nil {
+				return in.leaveFrame(err)
+			}
+			evaluated := unwrapReturnValue(in.evalTail(fn.Body, extendedEnv))
+
+			tc, ok := evaluated.(*tailCall)
+			if !ok {
+				// a body ending with a statement without value, like let or a loop
+				if evaluated == nil {
+					evaluated = NULL
+				}
+				return in.leaveFrame(evaluated)
+			}
+			if err := in.checkCancelled(); err != nil {
+				return in.leaveFrame(err)
+			}
+			fn, args = tc.fn, tc.args
+			in.frames[len(in.frames)-1] = object.Frame{Function: functionName(fn), Pos: tc.call.Pos(), Args: args}
+		}
 	case *object.Builtin:
-		return fn.Fn(args...)
+		for _, arg := range args {
+			if named, ok := arg.(*namedArgument); ok {
+				return newKindError(object.ArgumentError, "builtins take no named arguments, got %s", named.name)
+			}
+		}
+		// builtins return nil for null
+		if result := fn.Fn(args...); result != nil {
+			return result
+		}
+		return NULL
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newKindError(object.TypeError, "not a function: %s", fn.Type())
+	}
+
+}
+
+func functionName(fn *object.Function) string {
+	if fn.Name == "" {
+		return "<anonymous>"
 	}
+	return fn.Name
+}
 
+// an argument given by name, f(b: 2), on its way to the parameter
+type namedArgument struct {
+	name  string
+	value object.Object
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object) *object.Environment {
+func (na *namedArgument) Type() object.ObjectType { return "NAMED_ARGUMENT" }
+func (na *namedArgument) Inspect() string         { return na.name + ": " + na.value.Inspect() }
+
+// the environment of a call. Positional arguments fill the parameters in order and the rest
+// parameter takes what is left, named arguments go to the parameter with that name.
+// Each parameter destructures its argument, a missing one takes its default.
+func (in *interpreter) extendFunctionEnv(fn *object.Function, args []object.Object) (*object.Environment, object.Object) {
 	env := object.NewEnclosedEnvironment(fn.Env)
 
-	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+	// plain names given an argument each, the usual case
+	if len(args) == len(fn.Parameters) && plainCall(fn.Parameters, args) {
+		for i, param := range fn.Parameters {
+			env.Set(param.(*ast.BindingPattern).Name.Value, args[i])
+		}
+		return env, nil
+	}
+
+	params := fn.Parameters
+	var rest *ast.RestPattern
+	if n := len(params); n > 0 {
+		if r, ok := params[n-1].(*ast.RestPattern); ok {
+			rest, params = r, params[:n-1]
+		}
+	}
+	min, max := arity(fn.Parameters)
+
+	slots := make([]object.Object, len(params))
+	extra := []object.Object{}
+	positional := 0
+	for _, arg := range args {
+		named, ok := arg.(*namedArgument)
+		if !ok {
+			if positional < len(params) {
+				slots[positional] = arg
+			} else {
+				extra = append(extra, arg)
+			}
+			positional++
+			continue
+		}
+
+		i := parameterIndex(params, named.name)
+		if i < 0 {
+			return nil, newKindError(object.ArgumentError, "unknown parameter: %s", named.name)
+		}
+		if slots[i] != nil {
+			return nil, newKindError(object.ArgumentError, "argument %s given twice", named.name)
+		}
+		slots[i] = named.value
+	}
+
+	if len(extra) > 0 && rest == nil {
+		return nil, arityError(min, max, len(args))
+	}
+	for i, param := range params {
+		if _, ok := param.(*ast.DefaultPattern); ok || slots[i] != nil {
+			continue
+		}
+		if len(args) < min {
+			return nil, arityError(min, max, len(args))
+		}
+		return nil, newKindError(object.ArgumentError, "missing argument: %s", param.String())
+	}
+
+	bindings := make(map[string]object.Object, len(fn.Parameters))
+	for i, param := range params {
+		if err := in.bindPattern(param, slots[i], fn.Env, bindings, destructureMode); err != nil {
+			return nil, err
+		}
+	}
+	if rest != nil {
+		bindings[rest.Name.Value] = &object.Array{Elements: extra}
+	}
+	for _, param := range fn.Parameters {
+		for _, name := range patternNames(param) {
+			env.Set(name, bindings[name])
+		}
+	}
+
+	return env, nil
+}
+
+// only plain parameter names and positional arguments
+func plainCall(params []ast.Pattern, args []object.Object) bool {
+	for _, param := range params {
+		if _, ok := param.(*ast.BindingPattern); !ok {
+			return false
+		}
 	}
+	for _, arg := range args {
+		if _, ok := arg.(*namedArgument); ok {
+			return false
+		}
+	}
+	return true
+}
 
-	return env
+// how many arguments a function takes. Everything up to the last parameter without a default
+// is needed, max is -1 with a rest parameter
+func arity(params []ast.Pattern) (min, max int) {
+	max = len(params)
+	for i, param := range params {
+		switch param.(type) {
+		case *ast.RestPattern:
+			max = -1
+		case *ast.DefaultPattern:
+		default:
+			min = i + 1
+		}
+	}
+	return min, max
+}
+
+func arityError(min, max, got int) *object.Error {
+	switch {
+	case min == max:
+		return newKindError(object.ArgumentError, "wrong number of arguments: want=%d, got=%d", min, got)
+	case max < 0:
+		return newKindError(object.ArgumentError, "wrong number of arguments: want=at least %d, got=%d", min, got)
+	default:
+		return newKindError(object.ArgumentError, "wrong number of arguments: want=%d..%d, got=%d", min, max, got)
+	}
+}
+
+// the parameter a named argument is for, -1 when there is none
+func parameterIndex(params []ast.Pattern, name string) int {
+	for i, param := range params {
+		if d, ok := param.(*ast.DefaultPattern); ok {
+			param = d.Pattern
+		}
+		if b, ok := param.(*ast.BindingPattern); ok && b.Name.Value == name {
+			return i
+		}
+	}
+	return -1
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
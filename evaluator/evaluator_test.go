@@ -1,12 +1,23 @@
 package evaluator
 
 import (
+	"context"
+	"flag"
+	"lexer-parser/ast"
+	"lexer-parser/compiler"
 	"lexer-parser/lexer"
 	"lexer-parser/object"
 	"lexer-parser/parser"
+	"lexer-parser/vm"
+	"math"
+	"strings"
 	"testing"
+	"time"
 )
 
+// run the same cases on the bytecode vm with `go test ./evaluator -engine=vm`
+var engine = flag.String("engine", "eval", "the engine running the tests: eval or vm")
+
 func TestEvalIntegerExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -35,6 +46,187 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.5", 3.5},
+		{"-0.5", -0.5},
+		{".5 + .25", 0.75},
+		{"1.5 * 2", 3.0},
+		{"2 * 1.5", 3.0},
+		{"1 / 4.0", 0.25},
+		{"1 - 1e-1", 0.9},
+		{"1 / 4", 0},
+		{"1.5 < 2", true},
+		{"2 > 2.5", false},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestNumberConversions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{"int(7)", 7},
+		{`int("42")`, 42},
+		{"float(2)", 2.0},
+		{`float("1.25")`, 1.25},
+		{`int("x")`, `cannot convert "x" to INTEGER`},
+		{"int(1e20)", "100000000000000000000"},
+		{"int(-1e20)", "-100000000000000000000"},
+		{`int("123456789012345678901234567890")`, "123456789012345678901234567890"},
+		{"float(100000000000000000000)", 1e20},
+		{"float(true)", "argument to `float` not supported, got BOOLEAN"},
+		{`int(float("NaN"))`, "cannot convert NaN to INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case string:
+			if big, ok := evaluated.(*object.BigInt); ok {
+				testBigIntObject(t, big, expected)
+				continue
+			}
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"9223372036854775807 * 2", "18446744073709551614"},
+		{"-9223372036854775807 - 1", -9223372036854775808},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"-99999999999999999999", "-99999999999999999999"},
+		// small results are demoted
+		{"9223372036854775807 + 1 - 1", 9223372036854775807},
+		{"99999999999999999999 / 99999999999999999999", 1},
+		{"99999999999999999999 > 9223372036854775807", true},
+		{"99999999999999999999 == 99999999999999999999", true},
+		{"99999999999999999999 != 99999999999999999998", true},
+		{"99999999999999999999 < 1", false},
+		{"99999999999999999999 * 1.0", 1e20},
+		{`{99999999999999999999: 1}[99999999999999999999]`, 1},
+		{
+			`let fact = fn(n, acc) { if (n == 0) { acc } else { fact(n - 1, acc * n) } }; fact(25, 1)`,
+			"15511210043330985984000000",
+		},
+		{
+			`let fib = fn(n, a, b) { if (n == 0) { a } else { fib(n - 1, b, a + b) } }; fib(100, 0, 1)`,
+			"354224848179261915075",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			testBigIntObject(t, evaluated, expected)
+		}
+	}
+}
+
+// % is truncated like in Go, the result has the sign of the dividend
+func TestModuloExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7 % -3", 1},
+		{"-7 % -3", -1},
+		{"6 % 3", 0},
+		{"1 + 7 % 4 * 2", 7},
+		{"(-9223372036854775807 - 1) % -1", 0},
+		{"99999999999999999999 % 7", 1},
+		{"-99999999999999999999 % 7", -1},
+		{"7.5 % 2", 1.5},
+		{"-7.5 % 2", -1.5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "division by zero"},
+		{"1 % 0", "modulo by zero"},
+		{"1.5 / 0", "division by zero"},
+		{"1 / 0.0", "division by zero"},
+		{"1.5 % 0.0", "modulo by zero"},
+		{"99999999999999999999 / 0", "division by zero"},
+		{"99999999999999999999 % 0", "modulo by zero"},
+		{"let f = fn(x) { 10 / x }; f(0) + 1", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -67,6 +259,104 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestComparisonOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"2.5 >= 2", true},
+		{"2 <= 1.5", false},
+		{"99999999999999999999 >= 99999999999999999999", true},
+		{"99999999999999999999 <= 1", false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLogicalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"a\"", true},
+		{"if (false) { 1 } || 0", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		// the right side is not evaluated when the left decides
+		{"false && 1 / 0", false},
+		{"true || 1 / 0", true},
+		{"false && nope", false},
+		{"true || nope", true},
+		{"let f = fn() { nope }; true", true},
+		{"let x = []; len(x) > 0 && x[0] > 1", false},
+		{"let x = [5]; len(x) > 0 && x[0] > 1", true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestValueEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" == "a"`, true},
+		{`"a" != "a"`, false},
+		{`"a" == "b"`, false},
+		{`"a" + "b" == "ab"`, true},
+		{`[1, 2] == [1, 2]`, true},
+		{`[1, 2] == [2, 1]`, false},
+		{`[1, [2, "x"]] == [1, [2, "x"]]`, true},
+		{`[1, 2] != [1, 2, 3]`, true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`[] == {}`, false},
+		{`1 == "1"`, false},
+		{`[1] == [1.0]`, true},
+		{`if (false) { 1 } == if (false) { 2 }`, true},
+		{`let f = fn() { 1 }; f == f`, true},
+		{`fn() { 1 } == fn() { 1 }`, false},
+		{`len == len`, true},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestStringOrdering(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"a" < "ab"`, true},
+		{`"B" < "a"`, true},
+		{`"b" > "a"`, true},
+		{`"a" <= "a"`, true},
+		{`"a" >= "b"`, false},
+	}
+
+	for _, tt := range tests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -98,6 +388,10 @@ func TestIfElseExpression(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (1 > 2) { 10 } else if (2 > 1) { 20 } else { 30 }", 20},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 } else { 30 }", 30},
+		{"if (1 > 2) { 10 } else if (2 > 3) { 20 }", nil},
+		{"let sign = fn(x) { if (x < 0) { -1 } else if (x == 0) { 0 } else { 1 } }; sign(-5) + sign(0) * 10 + sign(7) * 100", 99},
 	}
 
 	for _, tt := range tests {
@@ -180,6 +474,18 @@ func TestErrorHandling(t *testing.T) {
 			"foobar",
 			"identifier not found: foobar",
 		},
+		{
+			"let f = fn() { nope + 1 }; f()",
+			"identifier not found: nope",
+		},
+		{
+			"if (false) { let y = 1; }; y + 1",
+			"identifier not found: y",
+		},
+		{
+			"let f = fn() { g() }; f(); let g = fn() { 1 };",
+			"identifier not found: g",
+		},
 		{
 			`"Hello" - "World"`,
 			"unknown operator: STRING - STRING",
@@ -222,6 +528,8 @@ func TestLetStatements(t *testing.T) {
 }
 
 func TestFunctionObject(t *testing.T) {
+	skipOnVM(t)
+
 	input := "fn(x) { x + 2; };"
 	evaluated := testEval(input)
 	fn, ok := evaluated.(*object.Function)
@@ -252,6 +560,8 @@ func TestFunctionApplication(t *testing.T) {
 		{"let add = fn(x, y) { x + y; }; add(5, 5);", 10},
 		{"let add = fn(x, y) { x + y; }; add(5 + 5, add(5, 5));", 20},
 		{"fn(x) { x; }(5)", 5},
+		// a function finds the globals defined after it
+		{"let f = fn() { g() }; let g = fn() { 1 }; f()", 1},
 	}
 	for _, tt := range tests {
 		testIntegerObject(t, testEval(tt.input), tt.expected)
@@ -486,11 +796,41 @@ func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
-	env := object.NewEnvironment()
 
+	if *engine == "vm" {
+		return testRun(program)
+	}
+
+	env := object.NewEnvironment()
 	return Eval(program, env)
 }
 
+// compile and run the program, errors are turned into error objects like the evaluator returns
+func testRun(program *ast.Program) object.Object {
+	comp := compiler.New()
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
+		}
+		return &object.Error{Message: err.Error()}
+	}
+	return machine.LastPoppedStackElem()
+}
+
+// for the tests looking at what only the evaluator runs or produces,
+// the compiler refuses those features (see compiler.Unsupported)
+func skipOnVM(t *testing.T) {
+	t.Helper()
+	if *engine == "vm" {
+		t.Skip("not supported by the vm")
+	}
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	result, ok := obj.(*object.Integer)
 	if !ok {
@@ -504,6 +844,33 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testBigIntObject(t *testing.T, obj object.Object, expected string) bool {
+	result, ok := obj.(*object.BigInt)
+	if !ok {
+		t.Errorf("object is not BigInt. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value.String() != expected {
+		t.Errorf("object has wrong value. got=%s, want=%s", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	// allow for the rounding of decimal fractions
+	if math.Abs(result.Value-expected) > 1e-9 {
+		t.Errorf("object has wrong value. got=%g, want=%g", result.Value, expected)
+		return false
+	}
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -520,9 +887,1037 @@ func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 }
 
 func testNullObject(t *testing.T, obj object.Object) bool {
-	if obj != NULL {
+	if obj != NULL && obj != vm.Null {
 		t.Errorf("object is not NULL. got=%T (%+v)", obj, obj)
 		return false
 	}
 	return true
 }
+
+func TestSyntaxErrorPlaceholders(t *testing.T) {
+	evaluated := testEval("let x = 1;\nlet y = ;")
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "syntax error at 2:1" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxSteps int64
+		expected interface{}
+	}{
+		{"let f = fn() { f() }; f()", 1000, "step limit exceeded"},
+		{"let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } }; f(10000)", 1000, "step limit exceeded"},
+		{"let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } }; f(10)", 1000, 0},
+		{"1 + 2", 0, 3},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(context.Background(), program, object.NewEnvironment(), Limits{MaxSteps: tt.maxSteps})
+
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	// the deadline passes while the loop runs
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelDeadline()
+
+	tests := []struct {
+		ctx   context.Context
+		input string
+	}{
+		{cancelled, "let f = fn(x) { if (x == 0) { 0 } else { f(x - 1) } }; f(10)"},
+		{deadline, "while (true) {}"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		start := time.Now()
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment(), Limits{})
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Fatalf("no error object returned for %q. got=%T(%+v)", tt.input, evaluated, evaluated)
+		}
+		if errObj.Message != "execution cancelled" {
+			t.Errorf("wrong error message for %q. got=%q", tt.input, errObj.Message)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%q was stopped late, after %s", tt.input, elapsed)
+		}
+	}
+}
+
+func TestRecursionDepthLimit(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxDepth int
+		expected string
+	}{
+		{
+			"let f = fn() { 1 + f() }; f()",
+			3,
+			"stack overflow: maximum call depth of 3 exceeded, most recent calls: f (1:20), f (1:20), f (1:20), f (1:27)",
+		},
+		{
+			"let f = fn(x) { f(x + 1) + 1 }; f(0)",
+			0,
+			"stack overflow: maximum call depth of 10000 exceeded, most recent calls: f (1:17), f (1:17), f (1:17), f (1:17), f (1:17)",
+		},
+		{
+			"fn() { fn() { 1 }() + fn() { 2 }() }()",
+			1,
+			"stack overflow: maximum call depth of 1 exceeded, most recent calls: <anonymous> (1:8), <anonymous> (1:1)",
+		},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(context.Background(), program, object.NewEnvironment(), Limits{MaxDepth: tt.maxDepth})
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("wrong error message.\nexpected=%q\ngot     =%q", tt.expected, errObj.Message)
+		}
+	}
+}
+
+// far deeper than DefaultMaxDepth, every tail call has to reuse the frame of its caller
+func TestTailCalls(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		// the last expression of the body
+		{"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(1000000, 0)", 1000000},
+		// a return statement
+		{"let count = fn(n, acc) { if (n == 0) { return acc; } return count(n - 1, acc + 1); }; count(1000000, 0)", 1000000},
+		// functions calling each other
+		{`
+			let isEven = fn(n) { if (n == 0) { 1 } else { isOdd(n - 1) } };
+			let isOdd = fn(n) { if (n == 0) { 0 } else { isEven(n - 1) } };
+			isEven(1000000)
+		`, 1},
+		// the helpers of the REPL
+		{`
+			let reduce = fn(arr, initial, f) { let iter = fn(arr, result) { if (len(arr) == 0) { result } else { iter(rest(arr), f(result, first(arr))); } }; iter(arr, initial); };
+			let build = fn(n, arr) { if (n == 0) { arr } else { build(n - 1, push(arr, n)) } };
+			reduce(build(5000, []), 0, fn(acc, x) { acc + x })
+		`, 12502500},
+		// a return in the middle of an expression leaves it, the call is never a value
+		{"let g = fn() { 1 }; let f = fn() { [if (true) { return g() }] }; f()", 1},
+		{"let g = fn() { 1 }; let f = fn() { let x = if (true) { return g() }; puts(x) }; f()", 1},
+		{`let g = fn() { 1 }; let f = fn() { {"a": match (1) { _ => { return g() } }} }; f()`, 1},
+		{"let count = fn(n) { if (n == 0) { return 0; } let x = if (true) { return count(n - 1) }; x }; count(100000)", 0},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestWhileLoops(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { let i = i + 1; }; i", 10},
+		{"let i = 0; while (false) { let i = i + 1; }; i", 0},
+		{"let sum = 0; let i = 0; while (i < 5) { let i = i + 1; let sum = sum + i; }; sum", 15},
+		{"let f = fn() { let i = 0; while (true) { if (i == 3) { return i; } let i = i + 1; } }; f()", 3},
+		{"let f = fn() { while (false) {} }; f()", nil},
+		{"while (1 / 0) {}", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestForLoops(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (let i = 0; i < 5; let i = i + 1) { let sum = sum + i; }; sum", 10},
+		{"let i = 10; for (; i > 0; let i = i - 3) {}; i", -2},
+		{"let n = 0; for (;;) { let n = n + 1; if (n == 4) { break; } }; n", 4},
+		{"for (let i = 0; i < 5; let i = i + 1) {}; i", 5},
+		{"for (let i = 0; i < x; let i = i + 1) {}", "identifier not found: x"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestForInLoops(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (x in [1, 2, 3]) { let sum = sum + x; }; sum", 6},
+		{"let n = 0; for (x in []) { let n = n + 1; }; n", 0},
+		{`let s = ""; for (c in "héllo") { let s = c + s; }; s`, "olléh"},
+		{`let ks = []; for (k in {"b": 1, 2: 2, "a": 3, true: 4, 1.5: 5}) { let ks = push(ks, k); }; ks == [1.5, 2, true, "a", "b"]`, true},
+		{"let sum = 0; for (i in range(5)) { let sum = sum + i; }; sum", 10},
+		{"let sum = 0; for (i in range(10, 0, -3)) { let sum = sum + i; }; sum", 22},
+		{"let sum = 0; for (i in range(3, 3)) { let sum = sum + i; }; sum", 0},
+		// near the ends of int64 the steps do not wrap around
+		{"let n = 0; for (x in range(9223372036854775800, 9223372036854775807, 5)) { let n = n + 1; }; n", 2},
+		{"let n = 0; for (x in range(-9223372036854775800, -9223372036854775807, -5)) { let n = n + 1; }; n", 2},
+		{"let last = 0; for (x in range(9223372036854775806, 9223372036854775807)) { let last = x; }; last", 9223372036854775806},
+		{"let a = [1, 2]; let n = 0; for (x in a) { let a = push(a, x); let n = n + 1; }; n", 2},
+		{"for (x in 5) {}", "cannot iterate over INTEGER"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (i in range(10)) { if (i == 5) { break; } let sum = sum + i; }; sum", 10},
+		{"let sum = 0; for (i in range(10)) { if (i % 2 == 0) { continue; } let sum = sum + i; }; sum", 25},
+		// continue runs the post statement
+		{"let n = 0; for (let i = 0; i < 5; let i = i + 1) { if (i < 3) { continue; } let n = n + 1; }; n", 2},
+		{"let i = 0; while (true) { let i = i + 1; if (i > 7) { break } }; i", 8},
+		// break only leaves the innermost loop
+		{`
+			let n = 0;
+			for (i in range(3)) {
+				for (j in range(3)) {
+					if (j == 1) { break; }
+					let n = n + 1;
+				}
+			};
+			n
+		`, 3},
+		// from inside an if inside a block
+		{"let n = 0; for (i in range(5)) { if (true) { if (i == 2) { break; } } let n = n + 1; }; n", 2},
+		// from an expression, the rest of it is not evaluated
+		{"let i = 0; while (i < 3) { let x = if (true) { break }; i += 1 }; i", 0},
+		{"let n = 0; while (n < 3) { n += 1; puts(if (true) { break }) }; n", 1},
+		{`let s = ""; for (x in ["a", "b", "c"]) { s += if (x == "b") { continue } else { x } }; s`, "ac"},
+		{"let n = 0; for (x in [1, 2]) { n += 1; [x, if (x == 1) { continue }, n += 10] }; n", 12},
+		{`let n = 0; for (x in [1, 2]) { n += 1; {"a": if (true) { break }} }; n`, 1},
+		{"let n = 0; for (x in [1, 2]) { n += 1; (if (true) { break }) + (n += 10) }; n", 1},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"range(5)", "range(0, 5, 1)"},
+		{"range(2, 5)", "range(2, 5, 1)"},
+		{"range(5, 0, -1)", "range(5, 0, -1)"},
+		{"range()", "wrong number of arguments. got=0, want=1..3"},
+		{`range("a")`, "argument to `range` must be INTEGER, got STRING"},
+		{"range(0, 5, 0)", "`range` step must not be 0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if errObj, ok := evaluated.(*object.Error); ok {
+			if errObj.Message != tt.expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", tt.expected, errObj.Message)
+			}
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong range. expected=%q, got=%q", tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+// the result of a program: an integer, a string, a boolean, an error message or null
+func testResultObject(t *testing.T, obj object.Object, expected interface{}) {
+	t.Helper()
+	switch expected := expected.(type) {
+	case int:
+		testIntegerObject(t, obj, int64(expected))
+	case bool:
+		testBooleanObject(t, obj, expected)
+	case nil:
+		testNullObject(t, obj)
+	case string:
+		switch obj := obj.(type) {
+		case *object.Error:
+			if obj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, obj.Message)
+			}
+		case *object.String:
+			if obj.Value != expected {
+				t.Errorf("wrong string. expected=%q, got=%q", expected, obj.Value)
+			}
+		default:
+			t.Errorf("object is not Error or String. got=%T (%+v)", obj, obj)
+		}
+	}
+}
+
+func TestAssignments(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = 2", 2},
+		{"let x = 1; let y = 1; x = y = 5; x + y", 10},
+		{"let x = 10; x += 5; x -= 3; x *= 2; x /= 4; x", 6},
+		{`let s = "a"; s += "b"; s`, "ab"},
+		// the binding of the enclosing scope is updated
+		{"let n = 0; let inc = fn() { n = n + 1 }; inc(); inc(); n", 2},
+		{"let counter = fn() { let c = 0; fn() { c += 1 } }; let next = counter(); next(); next(); next()", 3},
+		// let still shadows
+		{"let n = 0; let f = fn() { let n = 5; n = 6; n }; f() + n", 6},
+		{"let i = 0; while (i < 5) { i += 1 }; i", 5},
+		{"let sum = 0; for (let i = 0; i < 5; i += 1) { sum += i }; sum", 10},
+		{"x = 1", "cannot assign to undeclared identifier: x"},
+		{"x += 1", "identifier not found: x"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"let x = 1; x /= 0", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestIndexAssignments(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[0] = 5; a[0] + a[1]", 7},
+		{"let a = [1, 2, 3]; a[2] += 10; a[2]", 13},
+		{"let a = [1, 2, 3]; a[1] = 0", 0},
+		// arrays and hashes are changed in place, every reference sees it
+		{"let a = [1]; let b = a; b[0] = 9; a[0]", 9},
+		{"let set = fn(arr) { arr[0] = 4 }; let a = [0]; set(a); a[0]", 4},
+		{"let m = [[1, 2], [3, 4]]; m[1][0] = 7; m == [[1, 2], [7, 4]]", true},
+		{`let h = {"a": 1}; h["a"] = 2; h["b"] = 3; h["a"] + h["b"]`, 5},
+		{`let h = {"a": 1}; h["a"] *= 10; h["a"]`, 10},
+		{`let h = {}; h[true] = 1; h[1] = 2; h[true] + h[1]`, 3},
+		{"let a = [1, 2]; a[2] = 3", "index out of range: 2, length 2"},
+		{"let a = [1, 2]; a[-1] = 3", "index out of range: -1, length 2"},
+		{`let a = [1, 2]; a["x"] = 3`, "array index must be INTEGER, got STRING"},
+		{`let h = {}; h[fn(x) { x }] = 1`, "unusable as hash key: FUNCTION"},
+		{`let h = {}; h["a"] += 1`, "type mismatch: NULL + INTEGER"},
+		{`let s = "abc"; s[0] = "x"`, "index assignment not supported: STRING"},
+		{"let a = [1]; a[0] = b", "identifier not found: b"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+
+	// a value holding itself can still be shown
+	cycles := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[0] = a; a", "[[...]]"},
+		{`let h = {}; h["k"] = h; h`, "{k: {...}}"},
+		{`let a = [1]; let h = {"a": a}; a[0] = h; [a, a]`, "[[{a: [...]}], [{a: [...]}]]"},
+	}
+	for _, tt := range cycles {
+		if got := testEval(tt.input).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect of %q. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestConstants(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"const MAX_RETRIES = 3; MAX_RETRIES", 3},
+		{"const x = 1; x = 2", "cannot assign to constant: x"},
+		{"const x = 1; x += 2", "cannot assign to constant: x"},
+		{"const x = 1; let x = 2", "cannot redeclare constant: x"},
+		{"const x = 1; const x = 2", "cannot redeclare constant: x"},
+		{"const x = 1; for (x in [1]) {}", "cannot redeclare constant: x"},
+		{"const x = 1; let f = fn() { x = 2 }; f()", "cannot assign to constant: x"},
+		// a function scope can shadow it
+		{"const x = 1; let f = fn(x) { x = 2; x }; f(0) + x", 3},
+		{"const x = 1; let f = fn() { let x = 5; x += 1; x }; f() + x", 7},
+		{"let x = 1; const x = 2; x", 2},
+		// the elements of a constant array can change
+		{"const a = [1]; a[0] = 2; a[0]", 2},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (1) { 1 => "one", 2 => "two", _ => "many" }`, "one"},
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (7) { 1 => "one", 2 => "two", _ => "many" }`, "many"},
+		{`match (-1) { -1 => "minus one", _ => "other" }`, "minus one"},
+		{`match (1.0) { 1 => "number" }`, "number"},
+		{`match ("b") { "a" | "b" => "a or b", _ => "other" }`, "a or b"},
+		{`match (true) { false => 0, true => 1 }`, 1},
+		// names bind anything
+		{`match (5) { n => n * 2 }`, 10},
+		{`match ([1, 2]) { [x, y] => x + y }`, 3},
+		{`match ([1, 2, 3]) { [x, y] => 0, [x, y, z] => x + y + z }`, 6},
+		{`match ([1, [2, 3]]) { [a, [b, c]] => a * b * c }`, 6},
+		{`match ([1, 2]) { [2, x] | [x, 2] => x }`, 1},
+		{`match ("x") { [x] => 1, _ => 2 }`, 2},
+		{`match ({"name": "monkey", "age": 3}) { {"name": n} => n }`, "monkey"},
+		{`match ({"kind": "circle", "r": 2}) { {"kind": "square", "side": s} => s * s, {"kind": "circle", "r": r} => 3 * r * r }`, 12},
+		{`match ({1: true}) { {1: false} => 0, {1: true, 2: _} => 1, {1: x} => 2 }`, 2},
+		// guards
+		{`match (5) { n when n < 0 => "negative", n when n > 0 => "positive", _ => "zero" }`, "positive"},
+		{`match ([3, 1]) { [x, y] when x < y => y, [x, y] => x }`, 3},
+		// blocks as bodies, the arms do not leak their names
+		{`let n = 1; match (5) { n => { let m = n * 2; m } }; n`, 1},
+		{`let f = fn(x) { match (x) { 0 => { "zero" } _ => { "other" } } }; f(0)`, "zero"},
+		{`let total = 0; match (4) { n => { total += n } }; total`, 4},
+		// tail calls in the arms
+		{`let count = fn(n, acc) { match (n) { 0 => acc, _ => count(n - 1, acc + 1) } }; count(100000, 0)`, 100000},
+		{`match (3) { 1 => "one" }`, "non-exhaustive match: no arm matches 3"},
+		{`match ([1, "a"]) { [x, 1] => x }`, "non-exhaustive match: no arm matches [1, a]"},
+		{`match (1) { n when n / 0 => 1 }`, "division by zero"},
+		{`match (x) { _ => 1 }`, "identifier not found: x"},
+		// break and continue in an arm leave the loop around the match
+		{`let n = 0; while (n < 3) { n += 1; let x = match (n) { 1 => { break }, _ => 0 } }; n`, 1},
+		{`let n = 0; for (i in range(3)) { let x = match (i) { a when a < 2 => { continue }, _ => 0 }; n += 1 }; n`, 1},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+
+	// the parser only takes literals there, a tree built otherwise may hold anything
+	program := parser.New(lexer.New(`match ({"a": 1}) { 1 => 1, {"a": x} => x, _ => 2 }`)).ParseProgram()
+	match := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	nope := &ast.Identifier{Value: "nope"}
+	match.Arms[0].Pattern.(*ast.LiteralPattern).Value = nope
+	testResultObject(t, Eval(program, object.NewEnvironment()), "identifier not found: nope")
+
+	match.Arms[0].Pattern.(*ast.LiteralPattern).Value = &ast.IntegerLiteral{Value: 1}
+	match.Arms[1].Pattern.(*ast.HashPattern).Keys[0] = nope
+	testResultObject(t, Eval(program, object.NewEnvironment()), "identifier not found: nope")
+}
+
+func TestDestructuringLet(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; rest == [3, 4]", true},
+		{"let [a, ...rest] = [1]; rest == []", true},
+		{"let [a, b] = [1, 2, 3]; b", 2},
+		{"let [a, [b, c]] = [1, [2, 3]]; a + b + c", 6},
+		{"let [_, x] = [1, 2]; x", 2},
+		// missing elements are null, unless they have a default
+		{"let [a, b] = [1]; b", nil},
+		{"let [x = 5] = []; x", 5},
+		{"let [x = 5] = [7]; x", 7},
+		{"let [a, b = a * 2] = [3]; b", 6},
+		{`let {name, age: years} = {"name": "monkey", "age": 3}; years`, 3},
+		{`let {name, age: years} = {"name": "monkey", "age": 3}; name`, "monkey"},
+		{`let {name = "anon"} = {}; name`, "anon"},
+		{`let {missing} = {}; missing`, nil},
+		{`let {"tags": [first, ...others]} = {"tags": ["a", "b", "c"]}; others == ["b", "c"]`, true},
+		{`let {1: one, true: yes} = {1: "one", true: "yes"}; one + yes`, "oneyes"},
+		{`let [{x}, {x: y}] = [{"x": 1}, {"x": 2}]; x + y`, 3},
+		// every name is bound in the current scope
+		{"let a = 0; if (true) { let [a] = [1]; }; a", 1},
+		{"const [a, b] = [1, 2]; a = 3", "cannot assign to constant: a"},
+		{"const a = 1; let [a] = [2]", "cannot redeclare constant: a"},
+		{"let [a] = 5", "cannot destructure INTEGER with an array pattern"},
+		{`let {a} = [1]`, "cannot destructure ARRAY with a hash pattern"},
+		{"let [[a]] = [1]", "cannot destructure INTEGER with an array pattern"},
+		{"let [a = 1 / 0] = []", "division by zero"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestDestructuringParameters(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn([head, ...tail]) { head }; f([1, 2, 3])", 1},
+		{"let f = fn([head, ...tail]) { tail }; f([1, 2, 3]) == [2, 3]", true},
+		{"let sum = fn([head, ...tail]) { if (len(tail) == 0) { head } else { head + sum(tail) } }; sum([1, 2, 3, 4])", 10},
+		{`let greet = fn({name, greeting = "hello"}) { greeting + " " + name }; greet({"name": "monkey"})`, "hello monkey"},
+		{"let f = fn(a, b = a + 1) { a * b }; f(3)", 12},
+		{"let f = fn(a, b = a + 1) { a * b }; f(3, 5)", 15},
+		{"let f = fn(a, ...rest) { len(rest) }; f(1, 2, 3)", 2},
+		{"let f = fn(a, b) { b }; f(1)", "wrong number of arguments: want=2, got=1"},
+		{"let f = fn([a]) { a }; f(1)", "cannot destructure INTEGER with an array pattern"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestStrictDestructuring(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1]; b", "cannot destructure: no element 1 in array of length 1"},
+		{`let {name, age} = {"name": "x"}; age`, "cannot destructure: no key age in hash"},
+		{"let [a, b = 2] = [1]; b", 2},
+		{"let [a, ...rest] = [1]; len(rest)", 0},
+		{"let [a, b] = [1, 2, 3]; b", 2},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(context.Background(), program, object.NewEnvironment(), Limits{Strict: true})
+		testResultObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestArity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(a, b) { a + b }; f(1, 2)", 3},
+		{"let f = fn(a, b) { a + b }; f(1)", "wrong number of arguments: want=2, got=1"},
+		{"let f = fn(a, b) { a + b }; f(1, 2, 3)", "wrong number of arguments: want=2, got=3"},
+		{"fn() { 1 }(1)", "wrong number of arguments: want=0, got=1"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestCallArguments(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// defaults
+		{"let f = fn(a, b = 10) { a + b }; f(1)", 11},
+		{"let f = fn(a, b = 10) { a + b }; f(1, 2)", 3},
+		{"let f = fn(a, b = 10) { a + b }; f()", "wrong number of arguments: want=1..2, got=0"},
+		{"let f = fn(a, b = 10) { a + b }; f(1, 2, 3)", "wrong number of arguments: want=1..2, got=3"},
+		{"let f = fn(a = 1, b) { a + b }; f(5)", "wrong number of arguments: want=2, got=1"},
+		// rest
+		{"let f = fn(first, ...others) { others }; f(1, 2, 3) == [2, 3]", true},
+		{"let f = fn(first, ...others) { len(others) }; f(1)", 0},
+		{"let f = fn(first, ...others) { first }; f()", "wrong number of arguments: want=at least 1, got=0"},
+		{"let f = fn(...all) { all }; f() == []", true},
+		// spread
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(...[1, 2, 3])", 123},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(1, ...[2, 3])", 123},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(...[1, 2])", "wrong number of arguments: want=3, got=2"},
+		{"let f = fn(...all) { all }; f(...[1], 2, ...[3]) == [1, 2, 3]", true},
+		{"[0, ...[1, 2], 3] == [0, 1, 2, 3]", true},
+		{"len(...[[1, 2]])", 2},
+		{"let f = fn(a) { a }; f(...1)", "cannot spread INTEGER, want ARRAY"},
+		// named
+		{"let f = fn(a, b) { a - b }; f(b: 2, a: 1)", -1},
+		{"let f = fn(a, b) { a - b }; f(5, b: 2)", 3},
+		{"let f = fn(a, b = 10, c = 20) { a + b + c }; f(1, c: 2)", 13},
+		{"let f = fn(a, b) { a - b }; f(c: 2)", "unknown parameter: c"},
+		{"let f = fn(a, b) { a - b }; f(1, a: 2)", "argument a given twice"},
+		{"let f = fn(a, b) { a - b }; f(b: 2)", "wrong number of arguments: want=2, got=1"},
+		{"let f = fn(a, b, c) { a }; f(1, 2, b: 3)", "argument b given twice"},
+		{"let f = fn(a, b, c = 0) { a }; f(1, c: 2, a: 3)", "argument a given twice"},
+		{"let f = fn(a, b, c) { a }; f(1, c: 2, a: 3)", "argument a given twice"},
+		{"let f = fn(a, b, c) { b }; f(a: 1, c: 2, d: 3)", "unknown parameter: d"},
+		{"let f = fn(a, b, c) { b }; f(a: 1, c: 2, c: 3)", "argument c given twice"},
+		{"let f = fn(a, b, c = 0) { b }; f(a: 1, c: 2)", "missing argument: b"},
+		{`len(s: "abc")`, "builtins take no named arguments, got s"},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw "boom"; 1 } catch (e) { e["message"] }`, "boom"},
+		{`try { throw "boom" } catch (e) { e["type"] }`, "Error"},
+		{`try { throw 42 } catch (e) { e["value"] }`, 42},
+		{`try { throw {"type": "MyError", "message": "bad"} } catch (e) { e["type"] + ": " + e["message"] }`, "MyError: bad"},
+		{`try { 1 + "a" } catch (e) { e["message"] }`, "type mismatch: INTEGER + STRING"},
+		{`try { 1 + "a" } catch (e) { e["type"] }`, "TypeError"},
+		{`try { nope } catch (e) { e["type"] }`, "NameError"},
+		{`try { [1][5] = 2 } catch (e) { e["type"] }`, "IndexError"},
+		{`try { 1 / 0 } catch (e) { e["type"] }`, "ArithmeticError"},
+		{`try { fn(a) { a }() } catch (e) { e["type"] }`, "ArgumentError"},
+		// the builtins fail the same way
+		{`try { len(1, 2) } catch (e) { e["type"] }`, "ArgumentError"},
+		{`try { len(1) } catch (e) { e["type"] }`, "TypeError"},
+		{`try { int("x") } catch (e) { e["type"] }`, "ArgumentError"},
+		{`let f = fn() { throw "inner" }; try { f() } catch (e) { e["message"] }`, "inner"},
+		{`let f = fn() { throw "inner" }; let g = fn() { let r = f(); r }; try { g() } catch (e) { len(e["stack"]) }`, 2},
+		{`let f = fn() { throw "inner" }; try { f() } catch (e) { e["stack"][0] }`, "f() called at 1:39"},
+		{`let f = fn(x) { return g(x) }; let g = fn(x) { throw x }; try { f(3) } catch (e) { e["value"] }`, 3},
+		{`try { throw "a" } catch (e) { try { throw e } catch (err) { err["message"] } }`, "a"},
+		{`try { throw "a" } catch (e) { throw "b" }`, "b"},
+		{`throw "uncaught"; 1`, "uncaught"},
+		{`let x = 0; try { x = 1 } finally { x = x + 10 }; x`, 11},
+		{`let x = 0; try { try { throw "a" } finally { x = 5 } } catch (e) { x }`, 5},
+		{`try { 1 } finally { 2 }`, 1},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn() { try { throw "a" } catch (e) { return e["message"] } finally { 3 } }; f()`, "a"},
+		{`let n = 0; for (x in [1, 2, 3]) { try { if (x == 2) { break } } finally { n = n + 1 } }; n`, 2},
+	}
+
+	for _, tt := range tests {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestFatalErrorsAreNotCaught(t *testing.T) {
+	skipOnVM(t)
+
+	input := `let x = 0; let loop = fn() { while (true) { x += 1 } }; try { loop() } catch (e) { 1 } finally { x = 1 }`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	evaluated := EvalContext(context.Background(), program, object.NewEnvironment(), Limits{MaxSteps: 1000})
+
+	err, ok := evaluated.(*object.Error)
+	if !ok || !err.Fatal {
+		t.Fatalf("object is not a fatal Error. got=%T (%+v)", evaluated, evaluated)
+	}
+	if err.Message != "step limit exceeded" {
+		t.Errorf("wrong error message. got=%q", err.Message)
+	}
+}
+
+func TestStackOverflowIsCaught(t *testing.T) {
+	skipOnVM(t)
+
+	evaluated := testEval("let loop = fn(n) { 1 + loop(n + 1) }; loop(0)")
+	err, ok := evaluated.(*object.Error)
+	if !ok || err.Fatal || err.Kind != object.RecursionError {
+		t.Fatalf("object is not a RecursionError. got=%T (%+v)", evaluated, evaluated)
+	}
+	if !strings.HasPrefix(err.Message, "stack overflow") {
+		t.Errorf("wrong error message. got=%q", err.Message)
+	}
+
+	// the calls are left on the way to the catch, the program goes on
+	input := `let loop = fn(n) { 1 + loop(n + 1) };
+let caught = try { loop(0) } catch (e) { e["type"] };
+caught + "!"`
+	testResultObject(t, testEval(input), "RecursionError!")
+}
+
+func TestErrorStackTraces(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		pos      string
+		expected []string // the calls of the stack, innermost first
+	}{
+		{`1 + "a"`, "1:1", nil},
+		{"let x = 1;\nx + y", "2:5", nil},
+		{`let add = fn(a, b) { a + b }; add(1, "two")`, "1:22", []string{`add(1, "two") called at 1:31`}},
+		{
+			"let add = fn(a, b) { a + b };\nlet twice = fn(x) { let r = add(x, x); r };\ntwice(true)",
+			"1:22",
+			[]string{"add(true, true) called at 2:29", "twice(true) called at 3:1"},
+		},
+		{`fn(s) { throw s }("boom")`, "1:9", []string{`<anonymous>("boom") called at 1:1`}},
+		{`let f = fn(a) { a }; f(1, 2)`, "1:22", []string{"f(1, 2) called at 1:22"}},
+		// a tail call takes the frame of its caller
+		{`let f = fn(x) { g(x) }; let g = fn(x) { x / 0 }; f(1)`, "1:41", []string{"g(1) called at 1:17"}},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned for %q", tt.input)
+			continue
+		}
+		if errObj.Pos.String() != tt.pos {
+			t.Errorf("wrong position for %q. expected=%s, got=%s", tt.input, tt.pos, errObj.Pos)
+		}
+		if len(errObj.Stack) != len(tt.expected) {
+			t.Errorf("wrong stack for %q. expected=%d frames, got=%v", tt.input, len(tt.expected), errObj.Stack)
+			continue
+		}
+		for i, f := range errObj.Stack {
+			if got := f.Trace(); got != tt.expected[i] {
+				t.Errorf("stack[%d] wrong for %q. expected=%q, got=%q", i, tt.input, tt.expected[i], got)
+			}
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+		// a function may return a quote from its tail position
+		{`let f = fn() { quote(a + b) }; f()`, `(a + b)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(tt.input), tt.expected)
+	}
+	// the same array twice is no cycle
+	testQuoteObject(t, testEval(`let a = [1]; quote(unquote([a, a]))`), "[[1], [1]]")
+}
+
+func TestQuoteUnquote(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`quote(unquote(4 + 4) + 8)`, `(8 + 8)`},
+		{`let foobar = 8; quote(foobar)`, `foobar`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(true))`, `true`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`let quotedInfixExpression = quote(4 + 4); quote(unquote(4 + 4) + unquote(quotedInfixExpression))`, `(8 + (4 + 4))`},
+		{`quote(unquote("a" + "b"))`, `ab`},
+		{`quote(unquote([1, 2.5]))`, `[1, 2.5]`},
+		{`quote(unquote({"a": 1}))`, `{a:1}`},
+		// every call quotes the code as it is written
+		{`let f = fn(x) { quote(unquote(x) + 1) }; f(1); f(2)`, `(2 + 1)`},
+	}
+
+	for _, tt := range tests {
+		testQuoteObject(t, testEval(tt.input), tt.expected)
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote(nope))`, "identifier not found: nope"},
+		{`quote(unquote(fn(x) { x }))`, "cannot unquote FUNCTION"},
+		{`let a = [1]; a[0] = a; quote(unquote(a))`, "cannot unquote a cyclic value"},
+		{`let h = {"a": [1]}; h["a"][0] = h; quote(unquote([h]))`, "cannot unquote a cyclic value"},
+		{`quote(1, 2)`, "wrong number of arguments to quote: want=1, got=2"},
+		{`let m = macro(x) { x }; 1`, "macros can only be defined by a let at the top of a program"},
+	}
+
+	for _, tt := range errors {
+		testResultObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func testQuoteObject(t *testing.T, evaluated object.Object, expected string) {
+	t.Helper()
+	quote, ok := evaluated.(*object.Quote)
+	if !ok {
+		t.Fatalf("expected *object.Quote. got=%T (%+v)", evaluated, evaluated)
+	}
+	if quote.Node == nil {
+		t.Fatalf("quote.Node is nil")
+	}
+	if quote.Node.String() != expected {
+		t.Errorf("not equal. got=%q, want=%q", quote.Node.String(), expected)
+	}
+}
+
+func TestDefineMacros(t *testing.T) {
+	input := `
+	let number = 1;
+	let function = fn(x, y) { x + y };
+	let mymacro = macro(x, y) { x + y; };
+	`
+
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	DefineMacros(program, env)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("Wrong number of statements. got=%d", len(program.Statements))
+	}
+	if _, ok := env.Get("number"); ok {
+		t.Fatalf("number should not be defined")
+	}
+	if _, ok := env.Get("function"); ok {
+		t.Fatalf("function should not be defined")
+	}
+
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment.")
+	}
+	macro, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("Wrong number of macro parameters. got=%d", len(macro.Parameters))
+	}
+	if macro.Parameters[0].String() != "x" || macro.Parameters[1].String() != "y" {
+		t.Fatalf("wrong parameters. got=%v", macro.Parameters)
+	}
+	if macro.Body.String() != "(x + y)" {
+		t.Fatalf("body is not %q. got=%q", "(x + y)", macro.Body.String())
+	}
+}
+
+func TestExpandMacros(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let infixExpression = macro() { quote(1 + 2); }; infixExpression();`,
+			`(1 + 2)`,
+		},
+		{
+			`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); }; reverse(2 + 2, 10 - 5);`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`
+			let unless = macro(condition, consequence, alternative) {
+				quote(if (!(unquote(condition))) {
+					unquote(consequence);
+				} else {
+					unquote(alternative);
+				});
+			};
+
+			unless(10 > 5, puts("not greater"), puts("greater"));
+			`,
+			`if (!(10 > 5)) { puts("not greater") } else { puts("greater") }`,
+		},
+		{
+			`let assert = macro(condition) { quote(if (!(unquote(condition))) { throw "assertion failed: " + unquote(source(condition)) }) };
+			assert(x > 1)`,
+			`if (!(x > 1)) { throw "assertion failed: " + "(x > 1)" }`,
+		},
+		// the arguments are expanded first
+		{
+			`let twice = macro(x) { quote(unquote(x) * 2) }; twice(twice(1))`,
+			`((1 * 2) * 2)`,
+		},
+		// a let of the name ends the macro
+		{
+			`let m = macro() { quote(1) }; let m = fn() { 2 }; m()`,
+			`let m = fn() { 2 }; m()`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := parser.New(lexer.New(tt.expected)).ParseProgram()
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("cannot expand %q: %v", tt.input, err)
+		}
+
+		if expanded.String() != expected.String() {
+			t.Errorf("not equal. want=%q, got=%q", expected.String(), expanded.String())
+		}
+	}
+}
+
+// the code of a macro is at its call, the arguments where they are written
+func TestMacroPositions(t *testing.T) {
+	skipOnVM(t)
+
+	macro := "let assert = macro(c) { quote(if (!(unquote(c))) { throw \"assertion failed\" }) };\n"
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{macro + "let x = 1;\nassert(x == 2)", "3:1"},
+		{macro + "let x = 1;\n  assert(x + true)", "3:10"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("cannot expand %q: %v", tt.input, err)
+		}
+
+		errObj, ok := Eval(expanded, object.NewEnvironment()).(*object.Error)
+		if !ok {
+			t.Errorf("no error for %q", tt.input)
+			continue
+		}
+		if errObj.Pos.String() != tt.expected {
+			t.Errorf("wrong position for %q. expected=%s, got=%s", tt.input, tt.expected, errObj.Pos)
+		}
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(x) { quote(x) }; m()`, "wrong number of arguments to macro m: want=1, got=0"},
+		{`let m = macro() { 1 }; m()`, "macro m must return a QUOTE, got INTEGER"},
+		{`let m = macro() { }; m()`, "macro m must return a QUOTE, got NULL"},
+		{`let m = macro() { nope }; m()`, "identifier not found: nope"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+
+		_, err := ExpandMacros(program, env)
+		if err == nil {
+			t.Errorf("expected an error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+// the macros expand into code that runs like any other
+func TestMacros(t *testing.T) {
+	skipOnVM(t)
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) }; unless(1 > 2, "yes", "no")`, "yes"},
+		{`let assert = macro(c) { quote(if (!(unquote(c))) { throw "assertion failed: " + unquote(source(c)) }) }; let x = 1; assert(x > 1)`, "assertion failed: (x > 1)"},
+		{`let assert = macro(c) { quote(if (!(unquote(c))) { throw "assertion failed: " + unquote(source(c)) }) }; let x = 2; assert(x > 1); x`, 2},
+		// only the branch taken is evaluated
+		{`let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) }; unless(true, nope, 3)`, 3},
+		// a name bound in the program is no macro after it
+		{`let log = macro(e) { quote(0) }; let f = fn(log) { log(1) }; f(fn(x) { x * 10 })`, 10},
+		{`let assert = macro(c) { quote(throw "failed") }; let g = fn() { let assert = fn(x) { 99 }; assert(false) }; g()`, 99},
+		{`let assert = macro(c) { quote(0) }; assert(true); let assert = fn(x) { x }; assert(3)`, 3},
+		{`let m = macro() { quote(0) }; match (fn() { 4 }) { m => m() }`, 4},
+		{`let m = macro() { quote(0) }; try { throw "x" } catch (m) { m() }`, "not a function: HASH"},
+		{`let m = macro() { quote(0) }; let f = fn() { m() }; f()`, 0},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		env := object.NewEnvironment()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("cannot expand %q: %v", tt.input, err)
+		}
+		testResultObject(t, Eval(expanded, object.NewEnvironment()), tt.expected)
+	}
+
+	// a let at the top hides the macro from the next programs, like the next lines of the REPL
+	env := object.NewEnvironment()
+	for _, input := range []string{"let m = macro() { quote(1) }; let m = 2;", "m()"} {
+		program := parser.New(lexer.New(input)).ParseProgram()
+		DefineMacros(program, env)
+		expanded, err := ExpandMacros(program, env)
+		if err != nil {
+			t.Fatalf("cannot expand %q: %v", input, err)
+		}
+		if input == "m()" && expanded.String() != "m()" {
+			t.Errorf("m() is expanded to %q", expanded.String())
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package evaluator
+
+import (
+	"lexer-parser/ast"
+	"lexer-parser/object"
+)
+
+// throw <value>: a string is the message, a hash like the one catch hands out gives
+// the message and the type, anything else is shown as the message
+func (in *interpreter) evalThrowStatement(node *ast.ThrowStatement, env *object.Environment) object.Object {
+	val := in.eval(node.Value, env)
+	if stopsBlock(val) {
+		return val
+	}
+
+	err := &object.Error{Message: val.Inspect(), Kind: object.GenericError, Value: val}
+	switch val := val.(type) {
+	case *object.String:
+		err.Message = val.Value
+	case *object.Hash:
+		if message, ok := hashString(val, "message"); ok {
+			err.Message = message
+		}
+		if kind, ok := hashString(val, "type"); ok {
+			err.Kind = object.ErrorKind(kind)
+		}
+	}
+	return err
+}
+
+// the value of the block, or of the catch block when the block failed. The finally block runs
+// however the others were left, its own result only counts when it returns, breaks or fails.
+// Fatal errors skip both, the program has to stop
+func (in *interpreter) evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := in.applyReturnedCall(in.eval(node.Block, env))
+
+	if err, ok := result.(*object.Error); ok && !err.Fatal && node.Catch != nil {
+		catchEnv := object.NewEnclosedEnvironment(env)
+		catchEnv.Set(node.Param.Value, errorHash(err))
+		result = in.applyReturnedCall(in.eval(node.Catch, catchEnv))
+	}
+
+	if err, ok := result.(*object.Error); ok && err.Fatal {
+		return result
+	}
+	if node.Finally != nil {
+		if done := in.eval(node.Finally, env); stopsBlock(done) {
+			return done
+		}
+	}
+
+	return result
+}
+
+// a return in a function hands its call back to the trampoline, inside try it has to run
+// before leaving, so its errors are caught and finally comes after it
+func (in *interpreter) applyReturnedCall(result object.Object) object.Object {
+	rv, ok := result.(*object.ReturnValue)
+	if !ok {
+		return result
+	}
+	tc, ok := rv.Value.(*tailCall)
+	if !ok {
+		return result
+	}
+
+	val := in.applyFunction(tc.call, tc.fn, tc.args)
+	if isError(val) {
+		return val
+	}
+	return &object.ReturnValue{Value: val}
+}
+
+// what catch binds: {"message": ..., "type": ..., "stack": [...]} and the "value" thrown
+func errorHash(err *object.Error) *object.Hash {
+	kind := err.Kind
+	if kind == "" {
+		kind = object.GenericError
+	}
+
+	stack := make([]object.Object, len(err.Stack))
+	for i, f := range err.Stack {
+		stack[i] = &object.String{Value: f.Trace()}
+	}
+
+	hash := &object.Hash{Pairs: map[object.HashKey]object.HashPair{}}
+	setField(hash, "message", &object.String{Value: err.Message})
+	setField(hash, "type", &object.String{Value: string(kind)})
+	setField(hash, "stack", &object.Array{Elements: stack})
+	if err.Value != nil {
+		setField(hash, "value", err.Value)
+	}
+	return hash
+}
+
+func setField(hash *object.Hash, name string, val object.Object) {
+	key := &object.String{Value: name}
+	hash.Pairs[key.HashKey()] = object.HashPair{Key: key, Value: val}
+}
+
+func hashString(hash *object.Hash, name string) (string, bool) {
+	pair, ok := hash.Pairs[(&object.String{Value: name}).HashKey()]
+	if !ok {
+		return "", false
+	}
+	s, ok := pair.Value.(*object.String)
+	if !ok {
+		return "", false
+	}
+	return s.Value, true
+}
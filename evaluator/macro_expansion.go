@@ -0,0 +1,225 @@
+package evaluator
+
+import (
+	"context"
+	"lexer-parser/ast"
+	"lexer-parser/object"
+)
+
+// DefineMacros takes the `let <name> = macro(...) { ... }` statements at the top of program
+// out of it and binds the macros in env, for ExpandMacros.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	statements := []ast.Statement{}
+
+	for _, stmt := range program.Statements {
+		ls, ok := stmt.(*ast.LetStatement)
+		if !ok || ls.Name == nil {
+			statements = append(statements, stmt)
+			continue
+		}
+		macro, ok := ls.Value.(*ast.MacroLiteral)
+		if !ok {
+			statements = append(statements, stmt)
+			continue
+		}
+		env.Set(ls.Name.Value, &object.Macro{Parameters: macro.Parameters, Body: macro.Body, Env: env})
+	}
+
+	program.Statements = statements
+}
+
+// ExpandMacros expands the macros without any limit, see ExpandMacrosContext
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, error) {
+	return ExpandMacrosContext(context.Background(), program, env, Limits{})
+}
+
+// ExpandMacrosContext returns program with the calls of the macros of env replaced by the code
+// the macros return. The arguments are expanded before the call, the code a macro returns is not.
+// That code is put at the call, its nodes get the position of the call but for the arguments
+// in it, which keep theirs.
+// A name bound by a let, a parameter or a pattern is no macro in the code after it in its scope,
+// a let at the top of program hides the macro from the programs expanded after it too.
+// It fails with an *object.Error when a macro does not return a quote, or when running it fails
+// or goes over limits like in EvalContext.
+func ExpandMacrosContext(ctx context.Context, program ast.Node, env *object.Environment, limits Limits) (ast.Node, error) {
+	var failed *object.Error
+
+	shadowed := map[*ast.Identifier]bool{}
+	ast.Walk(&macroScope{names: map[string]bool{}, shadowed: shadowed}, program)
+
+	expanded := ast.Rewrite(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok || failed != nil {
+			return node
+		}
+		if ident, ok := call.Function.(*ast.Identifier); ok && shadowed[ident] {
+			return node
+		}
+		macro, ok := macroOf(call, env)
+		if !ok {
+			return node
+		}
+
+		quote, err := expandMacroCall(ctx, call, macro, limits)
+		if err != nil {
+			failed = err
+			return node
+		}
+		return atCall(quote.Node, call)
+	})
+	if failed != nil {
+		return nil, failed
+	}
+
+	if program, ok := program.(*ast.Program); ok {
+		for _, stmt := range program.Statements {
+			for _, name := range letNames(stmt) {
+				if obj, ok := env.Get(name); ok && obj.Type() == object.MACRO_OBJ {
+					env.Set(name, NULL)
+				}
+			}
+		}
+	}
+
+	return expanded, nil
+}
+
+// the code of a macro moved to its call, the arguments stay where they are
+func atCall(node ast.Node, call *ast.CallExpression) ast.Node {
+	arguments := map[ast.Node]bool{}
+	for _, arg := range call.Arguments {
+		ast.Inspect(arg, func(n ast.Node) bool {
+			arguments[n] = true
+			return true
+		})
+	}
+
+	pos, end := call.Pos(), call.End()
+	return ast.Rewrite(node, func(n ast.Node) ast.Node {
+		if arguments[n] {
+			return n
+		}
+		return ast.At(n, pos, end)
+	})
+}
+
+// macroScope finds the calls of names bound in the program, they are no macro calls.
+// names holds the names bound so far in the scope, shadowed the callees found
+type macroScope struct {
+	names    map[string]bool
+	outer    *macroScope
+	shadowed map[*ast.Identifier]bool
+}
+
+func (s *macroScope) bound(name string) bool {
+	return s.names[name] || s.outer != nil && s.outer.bound(name)
+}
+
+func (s *macroScope) enclosed(names []string) *macroScope {
+	inner := &macroScope{names: map[string]bool{}, outer: s, shadowed: s.shadowed}
+	for _, name := range names {
+		inner.names[name] = true
+	}
+	return inner
+}
+
+func (s *macroScope) Visit(node ast.Node) ast.Visitor {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		for _, name := range letNames(node) {
+			s.names[name] = true
+		}
+	case *ast.ForInStatement:
+		s.names[node.Variable.Value] = true
+	case *ast.FunctionLiteral:
+		names := []string{}
+		if node.Name != "" {
+			names = append(names, node.Name)
+		}
+		for _, param := range node.Parameters {
+			names = append(names, patternNames(param)...)
+		}
+		return s.enclosed(names)
+	case *ast.MacroLiteral:
+		names := []string{}
+		for _, param := range node.Parameters {
+			names = append(names, param.Value)
+		}
+		return s.enclosed(names)
+	case *ast.MatchArm:
+		return s.enclosed(patternNames(node.Pattern))
+	case *ast.TryExpression:
+		// the caught error is only bound in the catch block
+		ast.Walk(s, node.Block)
+		if node.Catch != nil {
+			ast.Walk(s.enclosed([]string{node.Param.Value}), node.Catch)
+		}
+		if node.Finally != nil {
+			ast.Walk(s, node.Finally)
+		}
+		return nil
+	case *ast.CallExpression:
+		if ident, ok := node.Function.(*ast.Identifier); ok && s.bound(ident.Value) {
+			s.shadowed[ident] = true
+		}
+	}
+	return s
+}
+
+// the names a let statement binds
+func letNames(stmt ast.Statement) []string {
+	ls, ok := stmt.(*ast.LetStatement)
+	switch {
+	case !ok:
+		return nil
+	case ls.Pattern != nil:
+		return patternNames(ls.Pattern)
+	default:
+		return []string{ls.Name.Value}
+	}
+}
+
+func macroOf(call *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	obj, ok := env.Get(ident.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+// run the body of macro with the arguments of call, unevaluated, as quotes
+func expandMacroCall(ctx context.Context, call *ast.CallExpression, macro *object.Macro, limits Limits) (*object.Quote, *object.Error) {
+	name := call.Function.String()
+	if len(call.Arguments) != len(macro.Parameters) {
+		err := newKindError(object.ArgumentError, "wrong number of arguments to macro %s: want=%d, got=%d",
+			name, len(macro.Parameters), len(call.Arguments))
+		err.Pos = call.Pos()
+		return nil, err
+	}
+
+	env := object.NewEnclosedEnvironment(macro.Env)
+	for i, param := range macro.Parameters {
+		env.Set(param.Value, &object.Quote{Node: call.Arguments[i]})
+	}
+
+	evaluated := unwrapReturnValue(EvalContext(ctx, macro.Body, env, limits))
+	switch evaluated := evaluated.(type) {
+	case *object.Quote:
+		return evaluated, nil
+	case *object.Error:
+		return nil, evaluated
+	default:
+		typ := object.ObjectType(object.NULL_OBJ)
+		if evaluated != nil {
+			typ = evaluated.Type()
+		}
+		err := newKindError(object.TypeError, "macro %s must return a QUOTE, got %s", name, typ)
+		err.Pos = call.Pos()
+		return nil, err
+	}
+}
@@ -0,0 +1,236 @@
+package evaluator
+
+import (
+	"lexer-parser/ast"
+	"lexer-parser/object"
+)
+
+// how a pattern treats a value it does not fit
+type patternMode int
+
+const (
+	// the arms of a match: the pattern does not match
+	matchMode patternMode = iota
+	// let and parameters: a value of another type is an error, a missing
+	// element or key is null, or an error with Limits.Strict
+	destructureMode
+)
+
+// returned by bindPattern when the value does not fit a pattern of a match arm
+var errNoMatch = &object.Error{Message: "no match"}
+
+// let [a, b] = <expression>; binds the names in the order of the pattern
+func (in *interpreter) evalDestructuring(ls *ast.LetStatement, val object.Object, env *object.Environment) object.Object {
+	bindings := make(map[string]object.Object)
+	if err := in.bindPattern(ls.Pattern, val, env, bindings, destructureMode); err != nil {
+		return err
+	}
+
+	for _, name := range patternNames(ls.Pattern) {
+		if err := env.Define(name, bindings[name], ls.IsConst()); err != nil {
+			return newKindError(object.NameError, "cannot redeclare constant: %s", name)
+		}
+	}
+	return nil
+}
+
+// add the names of pattern, bound to the parts of value, to bindings. A nil value is missing,
+// e.g. the element of an array too short. It returns nil when the value fits, errNoMatch when
+// it does not in a match and an error otherwise. Defaults and keys are evaluated in env.
+func (in *interpreter) bindPattern(pattern ast.Pattern, value object.Object, env *object.Environment,
+	bindings map[string]object.Object, mode patternMode) object.Object {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil
+	case *ast.BindingPattern:
+		if value == nil {
+			value = NULL
+		}
+		bindings[pattern.Name.Value] = value
+		return nil
+	case *ast.DefaultPattern:
+		if value == nil {
+			// the names bound so far are visible, like in fn(a, b = a)
+			scope := object.NewEnclosedEnvironment(env)
+			for name, v := range bindings {
+				scope.Set(name, v)
+			}
+			value = in.eval(pattern.Default, scope)
+			if stopsBlock(value) {
+				return value
+			}
+		}
+		return in.bindPattern(pattern.Pattern, value, env, bindings, mode)
+	case *ast.LiteralPattern:
+		if value == nil {
+			return errNoMatch
+		}
+		literal := in.eval(pattern.Value, env)
+		if stopsBlock(literal) {
+			return literal
+		}
+		if !object.Equals(literal, value) {
+			return errNoMatch
+		}
+		return nil
+	case *ast.AlternativePattern:
+		for _, alt := range pattern.Alternatives {
+			// the names bound by an alternative that does not match are dropped
+			tried := make(map[string]object.Object, len(bindings))
+			for name, v := range bindings {
+				tried[name] = v
+			}
+			if err := in.bindPattern(alt, value, env, tried, mode); err == errNoMatch {
+				continue
+			} else if err != nil {
+				return err
+			}
+			for name, v := range tried {
+				bindings[name] = v
+			}
+			return nil
+		}
+		return errNoMatch
+	case *ast.ArrayPattern:
+		return in.bindArrayPattern(pattern, value, env, bindings, mode)
+	case *ast.HashPattern:
+		return in.bindHashPattern(pattern, value, env, bindings, mode)
+	default:
+		return newError("unknown pattern: %s", pattern.String())
+	}
+}
+
+func (in *interpreter) bindArrayPattern(pattern *ast.ArrayPattern, value object.Object, env *object.Environment,
+	bindings map[string]object.Object, mode patternMode) object.Object {
+	var elements []object.Object
+	switch value := value.(type) {
+	case *object.Array:
+		elements = value.Elements
+	case nil:
+		// every element is missing as well
+	default:
+		if mode == matchMode {
+			return errNoMatch
+		}
+		return newKindError(object.MatchError, "cannot destructure %s with an array pattern", value.Type())
+	}
+
+	fixed := pattern.Elements
+	var rest *ast.RestPattern
+	if n := len(fixed); n > 0 {
+		if r, ok := fixed[n-1].(*ast.RestPattern); ok {
+			rest, fixed = r, fixed[:n-1]
+		}
+	}
+	if mode == matchMode && rest == nil && len(elements) > len(fixed) {
+		return errNoMatch
+	}
+
+	for i, el := range fixed {
+		var v object.Object
+		if i < len(elements) {
+			v = elements[i]
+		} else if err := in.missing(el, mode, "no element %d in array of length %d", i, len(elements)); err != nil {
+			return err
+		}
+		if err := in.bindPattern(el, v, env, bindings, mode); err != nil {
+			return err
+		}
+	}
+
+	if rest != nil {
+		left := []object.Object{}
+		if len(elements) > len(fixed) {
+			left = append(left, elements[len(fixed):]...)
+		}
+		bindings[rest.Name.Value] = &object.Array{Elements: left}
+	}
+	return nil
+}
+
+func (in *interpreter) bindHashPattern(pattern *ast.HashPattern, value object.Object, env *object.Environment,
+	bindings map[string]object.Object, mode patternMode) object.Object {
+	var hash *object.Hash
+	switch value := value.(type) {
+	case *object.Hash:
+		hash = value
+	case nil:
+		// every key is missing as well
+	default:
+		if mode == matchMode {
+			return errNoMatch
+		}
+		return newKindError(object.MatchError, "cannot destructure %s with a hash pattern", value.Type())
+	}
+
+	for i, keyNode := range pattern.Keys {
+		key := in.eval(keyNode, env)
+		if stopsBlock(key) {
+			return key
+		}
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newKindError(object.TypeError, "unusable as hash key: %s", key.Type())
+		}
+
+		var v object.Object
+		if hash != nil {
+			if pair, ok := hash.Pairs[hashKey.HashKey()]; ok {
+				v = pair.Value
+			}
+		}
+		if v == nil {
+			if err := in.missing(pattern.Values[i], mode, "no key %s in hash", key.Inspect()); err != nil {
+				return err
+			}
+		}
+		if err := in.bindPattern(pattern.Values[i], v, env, bindings, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the part of a value pattern destructures is missing: a default takes its place,
+// a match fails and strict destructuring is an error. Otherwise the names are null.
+func (in *interpreter) missing(pattern ast.Pattern, mode patternMode, format string, a ...interface{}) object.Object {
+	if _, ok := pattern.(*ast.DefaultPattern); ok {
+		return nil
+	}
+	if mode == matchMode {
+		return errNoMatch
+	}
+	if in.limits.Strict {
+		return newKindError(object.MatchError, "cannot destructure: "+format, a...)
+	}
+	return nil
+}
+
+// the names bound by pattern, in source order
+func patternNames(pattern ast.Pattern) []string {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		return []string{pattern.Name.Value}
+	case *ast.RestPattern:
+		return []string{pattern.Name.Value}
+	case *ast.DefaultPattern:
+		return patternNames(pattern.Pattern)
+	case *ast.AlternativePattern:
+		// every alternative binds the same names
+		return patternNames(pattern.Alternatives[0])
+	case *ast.ArrayPattern:
+		names := []string{}
+		for _, el := range pattern.Elements {
+			names = append(names, patternNames(el)...)
+		}
+		return names
+	case *ast.HashPattern:
+		names := []string{}
+		for _, value := range pattern.Values {
+			names = append(names, patternNames(value)...)
+		}
+		return names
+	default:
+		return nil
+	}
+}
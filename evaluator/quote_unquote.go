@@ -0,0 +1,115 @@
+package evaluator
+
+import (
+	"lexer-parser/ast"
+	"lexer-parser/object"
+	"lexer-parser/token"
+	"strconv"
+)
+
+func isQuoteCall(call *ast.CallExpression) bool {
+	return isCallTo(call, "quote")
+}
+
+func isCallTo(call *ast.CallExpression, name string) bool {
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+// quote(<expression>) is the expression itself instead of its value,
+// the unquote(<expression>) calls in it are replaced by the code of their values
+func (in *interpreter) quote(call *ast.CallExpression, env *object.Environment) object.Object {
+	if len(call.Arguments) != 1 {
+		return newKindError(object.ArgumentError, "wrong number of arguments to quote: want=1, got=%d", len(call.Arguments))
+	}
+
+	var failed object.Object
+	node := ast.Rewrite(call.Arguments[0], func(node ast.Node) ast.Node {
+		unquote, ok := node.(*ast.CallExpression)
+		if !ok || failed != nil || !isCallTo(unquote, "unquote") {
+			return node
+		}
+		if len(unquote.Arguments) != 1 {
+			failed = newKindError(object.ArgumentError, "wrong number of arguments to unquote: want=1, got=%d", len(unquote.Arguments))
+			return node
+		}
+
+		val := in.eval(unquote.Arguments[0], env)
+		if isError(val) {
+			failed = val
+			return node
+		}
+		converted, err := objectToNode(val, unquote, map[object.Object]bool{})
+		if err != nil {
+			failed = err
+			return node
+		}
+		return converted
+	})
+	if failed != nil {
+		return failed
+	}
+
+	return &object.Quote{Node: node}
+}
+
+// the code of a value, it takes the position of the unquote call it replaces.
+// seen holds the arrays and hashes being converted, one holding itself has no code
+func objectToNode(obj object.Object, at *ast.CallExpression, seen map[object.Object]bool) (ast.Node, *object.Error) {
+	tok := func(t token.TokenType, literal string) token.Token {
+		return token.Token{Type: t, Literal: literal, Pos: at.Pos(), End: at.End()}
+	}
+
+	switch obj.(type) {
+	case *object.Array, *object.Hash:
+		if seen[obj] {
+			return nil, newKindError(object.TypeError, "cannot unquote a cyclic value")
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+	}
+
+	switch obj := obj.(type) {
+	case *object.Quote:
+		return obj.Node, nil
+	case *object.Integer:
+		return &ast.IntegerLiteral{Token: tok(token.INT, obj.Inspect()), Value: obj.Value}, nil
+	case *object.BigInt:
+		return &ast.IntegerLiteral{Token: tok(token.INT, obj.Inspect()), Big: obj.Value}, nil
+	case *object.Float:
+		return &ast.FloatLiteral{Token: tok(token.FLOAT, strconv.FormatFloat(obj.Value, 'g', -1, 64)), Value: obj.Value}, nil
+	case *object.Boolean:
+		if obj.Value {
+			return &ast.Boolean{Token: tok(token.TRUE, "true"), Value: true}, nil
+		}
+		return &ast.Boolean{Token: tok(token.FALSE, "false"), Value: false}, nil
+	case *object.String:
+		return &ast.StringLiteral{Token: tok(token.STRING, obj.Value), Value: obj.Value}, nil
+	case *object.Array:
+		array := &ast.ArrayLiteral{Token: tok(token.LBRACKET, "["), Elements: make([]ast.Expression, len(obj.Elements))}
+		for i, el := range obj.Elements {
+			node, err := objectToNode(el, at, seen)
+			if err != nil {
+				return nil, err
+			}
+			array.Elements[i] = node.(ast.Expression)
+		}
+		return array, nil
+	case *object.Hash:
+		hash := &ast.HashLiteral{Token: tok(token.LBRACE, "{"), Pairs: make(map[ast.Expression]ast.Expression, len(obj.Pairs))}
+		for _, pair := range obj.SortedPairs() {
+			key, err := objectToNode(pair.Key, at, seen)
+			if err != nil {
+				return nil, err
+			}
+			value, err := objectToNode(pair.Value, at, seen)
+			if err != nil {
+				return nil, err
+			}
+			hash.Pairs[key.(ast.Expression)] = value.(ast.Expression)
+		}
+		return hash, nil
+	default:
+		return nil, newKindError(object.TypeError, "cannot unquote %s", obj.Type())
+	}
+}
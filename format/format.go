@@ -0,0 +1,241 @@
+// Package format prints programs in the canonical layout: blocks indented by four spaces,
+// one statement per line, only the parentheses the parser needs, and array, hash and
+// argument lists broken over lines when they do not fit in MaxWidth columns.
+// Formatting its own output gives the same output.
+package format
+
+import (
+	"bytes"
+	"lexer-parser/ast"
+	"lexer-parser/diagnostic"
+	"lexer-parser/lexer"
+	"lexer-parser/parser"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxWidth is the number of columns a line should fit in
+const MaxWidth = 80
+
+const indentation = "    "
+
+// Error is returned for a source with syntax errors, it cannot be formatted
+type Error struct {
+	Diagnostics []*diagnostic.Diagnostic
+}
+
+func (e *Error) Error() string {
+	lines := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		lines[i] = d.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Source parses src and returns it formatted, with its comments
+func Source(src string) (string, error) {
+	p := parser.New(lexer.NewWithComments(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return "", &Error{Diagnostics: p.Errors()}
+	}
+	f := &printer{src: src}
+	f.program(program)
+	return f.buf.String(), nil
+}
+
+// Node returns node formatted, a program ends with a line break and keeps its comments.
+// Where the positions of the nodes are known the blank lines between statements
+// and the blocks written on one line are kept.
+func Node(node ast.Node) string {
+	p := &printer{}
+	switch node := node.(type) {
+	case *ast.Program:
+		p.program(node)
+	case ast.Statement:
+		p.statement(node, false, nil)
+	case ast.Expression:
+		p.expression(node)
+	case ast.Pattern:
+		p.pattern(node)
+	case *ast.MatchArm:
+		p.matchArm(node)
+	}
+	return p.buf.String()
+}
+
+func (p *printer) program(program *ast.Program) {
+	p.comments = program.Comments
+	p.statements(program.Statements, false)
+	if len(program.Statements) == 0 {
+		p.commentLines(p.commentsOf(program).Leading)
+	}
+	if p.buf.Len() > 0 {
+		p.print("\n")
+	}
+}
+
+// printer writes the formatted code to buf. Layouts are tried out on a sub printer,
+// one printing on a single line fails as soon as it has to break the line
+type printer struct {
+	buf      bytes.Buffer
+	src      string // the source of the nodes, when there is one
+	comments ast.CommentMap
+	indent   int
+	col      int  // the column the next character goes to, starting at 0
+	oneLine  bool // breaking the line is a failure
+	failed   bool
+	ended    bool // a line comment was printed last, what comes next goes on the next line
+}
+
+// print writes s, which has line breaks only when it is one, or a block comment
+func (p *printer) print(s string) {
+	if p.ended {
+		p.ended = false
+		if !strings.HasPrefix(s, "\n") {
+			p.newline()
+			s = strings.TrimLeft(s, " ")
+		}
+	}
+	p.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.col = utf8.RuneCountInString(s[i+1:])
+		return
+	}
+	p.col += utf8.RuneCountInString(s)
+}
+
+// start a new line at the current indentation
+func (p *printer) newline() {
+	if p.oneLine {
+		p.failed = true
+		return
+	}
+	p.print("\n")
+	p.print(strings.Repeat(indentation, p.indent))
+}
+
+// a printer carrying on where p is, its output is added to p by write.
+// A line comment p printed last ends the line first
+func (p *printer) sub(oneLine bool) *printer {
+	if p.ended {
+		p.ended = false
+		p.newline()
+	}
+	return &printer{src: p.src, comments: p.comments, indent: p.indent, col: p.col, oneLine: oneLine || p.oneLine}
+}
+
+func (p *printer) write(q *printer) {
+	p.buf.Write(q.buf.Bytes())
+	p.col = q.col
+	p.failed = p.failed || q.failed
+	p.ended = q.ended
+}
+
+// fits reports whether q printed on one line within MaxWidth
+func (q *printer) fits() bool {
+	return !q.failed && q.col <= MaxWidth && !bytes.Contains(q.buf.Bytes(), []byte("\n"))
+}
+
+// list prints the items between open and close separated by commas: on one line when
+// they fit, with only the last one taking more lines when it opens a block or a literal,
+// e.g. a function passed last, or else one item per line. The comments of the items
+// go with them, a line comment breaks the list
+func (p *printer) list(open, close string, nodes []ast.Node, item func(q *printer, i int)) {
+	n := len(nodes)
+	if n == 0 {
+		p.print(open + close)
+		return
+	}
+
+	flat := p.sub(true)
+	flat.print(open)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			flat.print(", ")
+		}
+		flat.inline(nodes[i], func() { item(flat, i) })
+	}
+	flat.print(close)
+	if flat.fits() {
+		p.write(flat)
+		return
+	}
+
+	if !p.oneLine && p.comments[nodes[n-1]] == nil {
+		hug := p.sub(true)
+		hug.print(open)
+		for i := 0; i < n-1; i++ {
+			hug.inline(nodes[i], func() { item(hug, i) })
+			hug.print(", ")
+		}
+		if hug.fits() {
+			hug.oneLine = false
+			start := hug.buf.Len()
+			item(hug, n-1)
+			last := hug.buf.Bytes()[start:]
+			firstLine := hug.buf.Bytes()
+			if i := bytes.IndexByte(firstLine, '\n'); i >= 0 {
+				firstLine = firstLine[:i]
+			}
+			opens := bytes.HasSuffix(firstLine, []byte("{")) || bytes.HasSuffix(firstLine, []byte("["))
+			if bytes.Contains(last, []byte("\n")) && opens && p.col+utf8.RuneCount(firstLine) <= MaxWidth {
+				hug.print(close)
+				p.write(hug)
+				return
+			}
+		}
+	}
+
+	p.print(open)
+	p.indent++
+	p.newline()
+	p.lines(nodes, func(i int) {
+		item(p, i)
+		if i < n-1 {
+			p.print(",")
+		}
+	})
+	p.indent--
+	p.newline()
+	p.print(close)
+}
+
+// fill is list for short items, when they do not fit on one line
+// every line takes as many of them as fit. Items with comments take a line each
+func (p *printer) fill(open, close string, nodes []ast.Node, item func(q *printer, i int)) {
+	if p.anyComments(nodes) {
+		p.list(open, close, nodes, item)
+		return
+	}
+
+	n := len(nodes)
+	flat := p.sub(true)
+	flat.list(open, close, nodes, item)
+	if flat.fits() || p.oneLine {
+		p.write(flat)
+		return
+	}
+
+	p.print(open)
+	p.indent++
+	p.newline()
+	for i := 0; i < n; i++ {
+		q := &printer{src: p.src, oneLine: true}
+		item(q, i)
+		if i < n-1 {
+			q.print(",")
+		}
+		if i > 0 {
+			if p.col+1+q.col > MaxWidth {
+				p.newline()
+			} else {
+				p.print(" ")
+			}
+		}
+		p.print(q.buf.String())
+	}
+	p.indent--
+	p.newline()
+	p.print(close)
+}
@@ -0,0 +1,284 @@
+package format
+
+import (
+	"errors"
+	"lexer-parser/ast"
+	"lexer-parser/lexer"
+	"lexer-parser/parser"
+	"lexer-parser/token"
+	"strings"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// only the parentheses the parser needs
+		{"(5 + (2 * 3))", "5 + 2 * 3;\n"},
+		{"(5 + 2) * 3", "(5 + 2) * 3;\n"},
+		{"a - (b - c) - d", "a - (b - c) - d;\n"},
+		{"-(a + b) * -c", "-(a + b) * -c;\n"},
+		{"!(a && b) || (c || d)", "!(a && b) || (c || d);\n"},
+		{"-(-1); !(!x); - -y; -(!z); !(-w)", "-(-1);\n!(!x);\n-(-y);\n-!z;\n!-w;\n"},
+		{"(a == b) == c", "a == b == c;\n"},
+		{"a = (b = 1)", "a = b = 1;\n"},
+		{"x += (1 + 2)", "x += 1 + 2;\n"},
+		{"(a + b)(c); (f(x))[0]; (-f)(x); -(f(x))", "(a + b)(c);\nf(x)[0];\n(-f)(x);\n-f(x);\n"},
+		{"(fn(x) { x })(1)", "fn(x) { x }(1);\n"},
+		// statements, one per line
+		{"let a = 1 let b = 2 a + b", "let a = 1;\nlet b = 2;\na + b;\n"},
+		{"const x = [1,2 ,3];return x", "const x = [1, 2, 3];\nreturn x;\n"},
+		{"let a = 1;\n\n\n\nlet b = 2", "let a = 1;\n\nlet b = 2;\n"},
+		{"throw {\"message\": \"boom\"}", "throw {\"message\": \"boom\"};\n"},
+		// blocks are indented, the last statement is their value
+		{
+			"let f = fn(a, b) { let c = a + b;\n c }",
+			"let f = fn(a, b) {\n    let c = a + b;\n    c\n};\n",
+		},
+		{"let f = fn(a, b) { a + b; };", "let f = fn(a, b) { a + b };\n"},
+		{"let f = fn() {\n1\n};", "let f = fn() {\n    1\n};\n"},
+		{"fn() {}", "fn() {};\n"},
+		{
+			"while (i < 10) { i += 1; if (i == 5) { break } }",
+			"while (i < 10) {\n    i += 1;\n    if (i == 5) { break; }\n}\n",
+		},
+		{"for (let i = 0; i < 3; i += 1) { puts(i) }", "for (let i = 0; i < 3; i += 1) { puts(i) }\n"},
+		{"for (;;) { break }", "for (;;) { break; }\n"},
+		{"for (x in [1, 2]) { puts(x) }", "for (x in [1, 2]) { puts(x) }\n"},
+		{"if (a) { 1 } else if (b) { 2 } else { 3 }", "if (a) { 1 } else if (b) { 2 } else { 3 }\n"},
+		// the statement after one ending in a block could carry it on
+		{"if (a) { b }; -1", "if (a) { b };\n-1;\n"},
+		{"if (a) { b } c", "if (a) { b }\nc;\n"},
+		{
+			"try { f() } catch (e) { e[\"message\"] } finally { done() }",
+			"try { f() } catch (e) { e[\"message\"] } finally { done() }\n",
+		},
+		{
+			"match (v) { 1 | -2 => \"small\", [a, ...rest] when a > 1 => { a }, {name, age = 1} => ({\"n\": name}), _ => 0 }",
+			"match (v) {\n    1 | -2 => \"small\",\n    [a, ...rest] when a > 1 => { a }\n    {name, age = 1} => ({\"n\": name}),\n    _ => 0,\n}\n",
+		},
+		{"let [a, {x: [b], y = 2}] = v", "let [a, {x: [b], y = 2}] = v;\n"},
+		{"let {\"k\": v, 1: w} = h", "let {\"k\": v, 1: w} = h;\n"},
+		{"fn(a, b = 2, ...rest) { rest }", "fn(a, b = 2, ...rest) { rest };\n"},
+		{"f(1, ...xs, name: 2)", "f(1, ...xs, name: 2);\n"},
+		{"macro(a, b) { quote(unquote(a) + unquote(b)) }", "macro(a, b) { quote(unquote(a) + unquote(b)) };\n"},
+		{"1.50 + 1e3 + .5", "1.50 + 1e3 + .5;\n"},
+		// strings are written as they are
+		{`puts("a\tb\n", "\u{1F600}")`, "puts(\"a\\tb\\n\", \"\\u{1F600}\");\n"},
+		{"let s = `raw\n  lines`", "let s = `raw\n  lines`;\n"},
+		{"fn() {\nlet s = \"\"\"\n  text\n  \"\"\"; s }", "fn() {\n    let s = \"\"\"\n  text\n  \"\"\";\n    s\n};\n"},
+		// long lists take one element per line
+		{
+			"let xs = [first(aaaaaaaaaa), first(bbbbbbbbbb), first(cccccccccc), first(dddddddddd)]",
+			"let xs = [\n    first(aaaaaaaaaa),\n    first(bbbbbbbbbb),\n    first(cccccccccc),\n    first(dddddddddd)\n];\n",
+		},
+		// unless they are literals, these fill the lines
+		{
+			"let xs = [1111111111, 2222222222, 3333333333, 4444444444, 5555555555, 6666666666, 7777777777]",
+			"let xs = [\n    1111111111, 2222222222, 3333333333, 4444444444, 5555555555, 6666666666,\n    7777777777\n];\n",
+		},
+		{
+			"if (aaaaaaaaaaaaaaaaaaaa == bbbbbbbbbbbbbbbbbbbbbbbbb) { result } else { iter(rest(arr)) }",
+			"if (aaaaaaaaaaaaaaaaaaaa == bbbbbbbbbbbbbbbbbbbbbbbbb) {\n    result\n} else {\n    iter(rest(arr))\n}\n",
+		},
+		{
+			`let h = {"name": "monkey", "age": 1, "languages": ["go", "rust", "c"], "nested": {"a": 1}}`,
+			"let h = {\n    \"name\": \"monkey\",\n    \"age\": 1,\n    \"languages\": [\"go\", \"rust\", \"c\"],\n    \"nested\": {\"a\": 1}\n};\n",
+		},
+		{
+			"describe(\"a rather long description of the thing\", \"and another argument\", 12345)",
+			"describe(\n    \"a rather long description of the thing\",\n    \"and another argument\",\n    12345\n);\n",
+		},
+		// unless only the last argument needs more lines
+		{
+			"map(list, fn(x) { let y = x * 2; y + 1 })",
+			"map(list, fn(x) {\n    let y = x * 2;\n    y + 1\n});\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("cannot format %q: %s", tt.input, err)
+			continue
+		}
+		if formatted != tt.expected {
+			t.Errorf("wrong format of %q.\nexpected=%q\ngot=     %q", tt.input, tt.expected, formatted)
+			continue
+		}
+		if again, _ := Source(formatted); again != formatted {
+			t.Errorf("formatting %q again gives %q", formatted, again)
+		}
+	}
+}
+
+// operators next to each other read back as they were
+func TestPrefixRoundTrip(t *testing.T) {
+	for _, input := range []string{"-(-1)", "!(!x)", "-(-(-a))", "!(!(a == b))", "-(!x) + !(-y)"} {
+		formatted, err := Source(input)
+		if err != nil {
+			t.Errorf("cannot format %q: %s", input, err)
+			continue
+		}
+		if parse(t, formatted).String() != parse(t, input).String() {
+			t.Errorf("formatting %q changes the program to %q", input, formatted)
+		}
+		if strings.Contains(formatted, "--") || strings.Contains(formatted, "!!") {
+			t.Errorf("formatting %q runs the operators together: %q", input, formatted)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"// one\nlet a = 1 // two\n", "// one\nlet a = 1; // two\n"},
+		{"/* a */ let a = 1", "/* a */\nlet a = 1;\n"},
+		{"// only a comment", "// only a comment\n"},
+		{"let a = 1;\n\n// about b\nlet b = 2;", "let a = 1;\n\n// about b\nlet b = 2;\n"},
+		{"let a = 1;\n// after a\n\nlet b = 2", "let a = 1;\n// after a\n\nlet b = 2;\n"},
+		// blocks with comments in them take lines
+		{"fn() { x // the value\n}", "fn() {\n    x // the value\n};\n"},
+		{"fn() { /* nothing */ }", "fn() {\n    /* nothing */\n};\n"},
+		{"let a = fn() { 1 } // one", "let a = fn() { 1 }; // one\n"},
+		{
+			"match (v) {\n// zero\n0 => a, // a\n_ => b }",
+			"match (v) {\n    // zero\n    0 => a, // a\n    _ => b,\n}\n",
+		},
+		{"/* one\n   two */\nx", "/* one\n   two */\nx;\n"},
+		{"let x = /* one */ 1;", "/* one */\nlet x = 1;\n"},
+		// the comments of elements, pairs, arguments and parameters stay with them
+		{"let a = [\n1, // one\n2, // two\n3\n];", "let a = [\n    1, // one\n    2, // two\n    3\n];\n"},
+		{"let a = [1, /* one */ 2];", "let a = [1, /* one */ 2];\n"},
+		{
+			"let h = {\n\"a\": 1, // first\n// about b\n\"b\": 2};",
+			"let h = {\n    \"a\": 1, // first\n    // about b\n    \"b\": 2\n};\n",
+		},
+		{"f(x, // the x\ny)", "f(\n    x, // the x\n    y\n);\n"},
+		{"let f = fn(a, // the a\nb) { a + b };", "let f = fn(\n    a, // the a\n    b\n) { a + b };\n"},
+		{"if (x) { a } // then\nelse { b }", "if (x) {\n    a\n} // then\nelse {\n    b\n}\n"},
+		{"let x = fn() { 1 } // one\n(2)", "let x = fn() {\n    1\n} // one\n(2);\n"},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source(tt.input)
+		if err != nil {
+			t.Errorf("cannot format %q: %s", tt.input, err)
+			continue
+		}
+		if formatted != tt.expected {
+			t.Errorf("wrong format of %q.\nexpected=%q\ngot=     %q", tt.input, tt.expected, formatted)
+			continue
+		}
+		if again, _ := Source(formatted); again != formatted {
+			t.Errorf("formatting %q again gives %q", formatted, again)
+		}
+	}
+}
+
+const program = `
+// the numbers
+let fibonacci = fn(x) { if (x < 2) { return x; } fibonacci(x - 1) + fibonacci(x - 2) }; /* slow */
+
+
+let reduce = fn(arr, initial, f) {
+let iter = fn(arr, result) { // arr gets shorter
+if (len(arr) == 0) { result } else { iter(rest(arr), f(result, first(arr))); } };
+iter(arr, initial); };
+let sum = fn(arr) { reduce(arr, 0, fn(initial, el) { initial + el }) };
+let classify = fn(v) { match (v) { 0 => "zero", n when n < 0 => "negative", [x, ...rest] => { let s = sum(rest); x + s }, _ => "other" } };
+let result = try { classify([1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25]) } catch (e) { puts(e["message"]); 0 };
+puts(fibonacci(10), sum([1, 2, 3]), result, (1 + 2) * 3 - -4, !(true && false) || (false || true))
+`
+
+func TestIdempotent(t *testing.T) {
+	formatted, err := Source(program)
+	if err != nil {
+		t.Fatalf("cannot format: %s", err)
+	}
+
+	again, err := Source(formatted)
+	if err != nil {
+		t.Fatalf("cannot format the formatted program: %s\n%s", err, formatted)
+	}
+	if again != formatted {
+		t.Errorf("formatting again changes the program.\nfirst:\n%s\nagain:\n%s", formatted, again)
+	}
+
+	// and it is still the same program
+	if parse(t, formatted).String() != parse(t, program).String() {
+		t.Errorf("formatting changes the program.\nbefore: %s\nafter:  %s", parse(t, program), parse(t, formatted))
+	}
+
+	for i, line := range strings.Split(formatted, "\n") {
+		if len(line) > MaxWidth {
+			t.Errorf("line %d is longer than %d: %q", i+1, MaxWidth, line)
+		}
+		if strings.TrimRight(line, " ") != line {
+			t.Errorf("line %d ends in spaces: %q", i+1, line)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Source("let x = (1 + 2;")
+
+	var syntaxErr *Error
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected *Error, got=%T (%v)", err, err)
+	}
+	if len(syntaxErr.Diagnostics) != 1 {
+		t.Fatalf("wrong number of diagnostics. got=%d", len(syntaxErr.Diagnostics))
+	}
+	if err.Error() != "1:15: error[P001]: expected next token to be ), got ; instead" {
+		t.Errorf("wrong message. got=%q", err.Error())
+	}
+}
+
+// nodes made up without tokens or positions
+func TestNode(t *testing.T) {
+	ident := func(name string) *ast.Identifier { return &ast.Identifier{Value: name} }
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{
+			&ast.InfixExpression{
+				Left:     &ast.InfixExpression{Left: ident("a"), Operator: "+", Right: ident("b")},
+				Operator: "*",
+				Right:    &ast.InfixExpression{Left: ident("c"), Operator: "*", Right: ident("d")},
+			},
+			"(a + b) * (c * d)",
+		},
+		{&ast.FloatLiteral{Value: 2}, "2.0"},
+		{&ast.IntegerLiteral{Value: 42}, "42"},
+		{&ast.StringLiteral{Value: "say \"hi\"\n"}, `"say \"hi\"\n"`},
+		{
+			&ast.LetStatement{Token: token.Token{Type: token.LET}, Name: ident("f"), Value: &ast.FunctionLiteral{
+				Body: &ast.BlockStatement{Statements: []ast.Statement{&ast.ExpressionStatement{Expression: ident("x")}}},
+			}},
+			"let f = fn() {\n    x\n};",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := Node(tt.node); got != tt.expected {
+			t.Errorf("wrong format. expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,615 @@
+package format
+
+import (
+	"lexer-parser/ast"
+	"lexer-parser/lexer"
+	"lexer-parser/parser"
+	"lexer-parser/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// statements prints one statement per line, a blank line between two of them is kept.
+// The last statement of a block is its value and goes without ";"
+func (p *printer) statements(stmts []ast.Statement, inBlock bool) {
+	nodes := make([]ast.Node, len(stmts))
+	for i, stmt := range stmts {
+		nodes[i] = stmt
+	}
+
+	p.lines(nodes, func(i int) {
+		var next ast.Statement
+		if i+1 < len(stmts) {
+			next = stmts[i+1]
+		}
+		p.statement(stmts[i], inBlock && next == nil, next)
+	})
+}
+
+// lines prints nodes one per line with their comments, the comments after a node
+// on its line stay there. A blank line between two of them is kept
+func (p *printer) lines(nodes []ast.Node, print func(i int)) {
+	var prev token.Position
+	first := true
+	next := func(pos token.Position) {
+		if !first {
+			if prev.IsValid() && pos.IsValid() && pos.Line > prev.Line+1 {
+				p.print("\n")
+			}
+			p.newline()
+		}
+		first = false
+	}
+
+	for i, node := range nodes {
+		comments := p.commentsOf(node)
+		for _, c := range comments.Leading {
+			next(c.Pos())
+			p.comment(c)
+			prev = c.End()
+		}
+
+		next(node.Pos())
+		print(i)
+		end := node.End()
+		prev = end
+
+		for _, c := range comments.Trailing {
+			if c.Pos().Line == end.Line {
+				p.print(" ")
+			} else {
+				next(c.Pos())
+			}
+			p.comment(c)
+			prev = c.End()
+		}
+	}
+}
+
+func (p *printer) commentsOf(node ast.Node) *ast.Comments {
+	if c := p.comments[node]; c != nil {
+		return c
+	}
+	return &ast.Comments{}
+}
+
+// nothing but a line break can follow a line comment
+func (p *printer) comment(c *ast.Comment) {
+	p.print(c.Token.Literal)
+	if !c.IsBlock() {
+		p.ended = true
+		p.failed = p.failed || p.oneLine
+	}
+}
+
+// inline prints node with its comments around it on the same line
+func (p *printer) inline(node ast.Node, print func()) {
+	comments := p.commentsOf(node)
+	for _, c := range comments.Leading {
+		p.comment(c)
+		p.print(" ")
+	}
+	print()
+	p.trailing(node)
+}
+
+// the comments after node on its line
+func (p *printer) trailing(node ast.Node) {
+	for _, c := range p.commentsOf(node).Trailing {
+		p.print(" ")
+		p.comment(c)
+	}
+}
+
+func (p *printer) anyComments(nodes []ast.Node) bool {
+	for _, node := range nodes {
+		if p.comments[node] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// the comments of an empty block, match or program are inside it
+func (p *printer) emptyBody(node ast.Node) {
+	comments := p.commentsOf(node).Leading
+	if len(comments) == 0 {
+		return
+	}
+	p.indent++
+	p.newline()
+	p.commentLines(comments)
+	p.indent--
+	p.newline()
+}
+
+func (p *printer) commentLines(comments []*ast.Comment) {
+	nodes := make([]ast.Node, len(comments))
+	for i, c := range comments {
+		nodes[i] = c
+	}
+	p.lines(nodes, func(i int) { p.comment(comments[i]) })
+}
+
+func (p *printer) statement(stmt ast.Statement, last bool, next ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.letStatement(stmt)
+		p.print(";")
+	case *ast.ReturnStatement:
+		p.print("return")
+		if stmt.ReturnValue != nil {
+			p.print(" ")
+			p.expression(stmt.ReturnValue)
+		}
+		p.print(";")
+	case *ast.ThrowStatement:
+		p.print("throw ")
+		p.expression(stmt.Value)
+		p.print(";")
+	case *ast.BreakStatement:
+		p.print("break;")
+	case *ast.ContinueStatement:
+		p.print("continue;")
+	case *ast.WhileStatement:
+		p.print("while (")
+		p.expression(stmt.Condition)
+		p.print(") ")
+		p.block(stmt.Body)
+	case *ast.ForStatement:
+		p.print("for (")
+		if stmt.Init != nil {
+			p.forClause(stmt.Init)
+		}
+		p.print(";")
+		if stmt.Condition != nil {
+			p.print(" ")
+			p.expression(stmt.Condition)
+		}
+		p.print(";")
+		if stmt.Post != nil {
+			p.print(" ")
+			p.forClause(stmt.Post)
+		}
+		p.print(") ")
+		p.block(stmt.Body)
+	case *ast.ForInStatement:
+		p.print("for (" + stmt.Variable.Value + " in ")
+		p.expression(stmt.Iterable)
+		p.print(") ")
+		p.block(stmt.Body)
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression)
+		if needsSemicolon(stmt, last, next) {
+			p.print(";")
+		}
+	case *ast.BlockStatement:
+		p.block(stmt)
+	default:
+		p.print(stmt.String())
+	}
+}
+
+func (p *printer) letStatement(stmt *ast.LetStatement) {
+	if stmt.Token.Type == token.CONST {
+		p.print("const ")
+	} else {
+		p.print("let ")
+	}
+	if stmt.Pattern != nil {
+		p.pattern(stmt.Pattern)
+	} else {
+		p.print(stmt.Name.Value)
+	}
+	p.print(" = ")
+	p.expression(stmt.Value)
+}
+
+// the init and post statements of a for loop, the loop has the ";" after them
+func (p *printer) forClause(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		p.letStatement(stmt)
+	case *ast.ExpressionStatement:
+		p.expression(stmt.Expression)
+	default:
+		p.statement(stmt, true, nil)
+	}
+}
+
+// an expression ending in a block needs no ";", unless the next statement
+// would carry it on, e.g. `if (a) { b } -1` is a subtraction
+func needsSemicolon(stmt *ast.ExpressionStatement, last bool, next ast.Statement) bool {
+	switch stmt.Expression.(type) {
+	case *ast.IfExpression, *ast.MatchExpression, *ast.TryExpression:
+		return next != nil && continuesExpression(next)
+	}
+	return !last
+}
+
+func continuesExpression(stmt ast.Statement) bool {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+	q := &printer{oneLine: true}
+	q.expression(es.Expression)
+	return strings.IndexAny(q.buf.String(), "-([") == 0
+}
+
+// a block written on one line stays there when it holds one statement and fits
+func (p *printer) block(block *ast.BlockStatement) {
+	p.blockLines(block, true)
+}
+
+func (p *printer) blockLines(block *ast.BlockStatement, oneLine bool) {
+	if len(block.Statements) == 0 {
+		p.print("{")
+		p.emptyBody(block)
+		p.print("}")
+		p.trailing(block)
+		return
+	}
+
+	if oneLine && len(block.Statements) == 1 && p.comments[block.Statements[0]] == nil &&
+		block.Token.Pos.IsValid() && block.Token.Pos.Line == block.Rbrace.Pos.Line {
+		q := p.sub(true)
+		q.print("{ ")
+		q.statement(block.Statements[0], true, nil)
+		q.print(" }")
+		q.trailing(block)
+		if q.fits() {
+			p.write(q)
+			return
+		}
+	}
+
+	p.print("{")
+	p.indent++
+	p.newline()
+	p.statements(block.Statements, true)
+	p.indent--
+	p.newline()
+	p.print("}")
+	p.trailing(block)
+}
+
+// how tightly an expression holds together, the operands binding less need parentheses
+func precedence(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(token.TokenType(exp.Operator))
+	case *ast.LogicalExpression:
+		return parser.Precedence(token.TokenType(exp.Operator))
+	case *ast.AssignExpression:
+		return parser.ASSIGN
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	}
+	// literals and the expressions ending in a bracket or a block
+	return parser.INDEX + 1
+}
+
+func (p *printer) operand(exp ast.Expression, parens bool) {
+	if parens {
+		p.print("(")
+		p.expression(exp)
+		p.print(")")
+		return
+	}
+	p.expression(exp)
+}
+
+// the operators group to the left, so the right operand needs parentheses
+// when it binds just as tightly
+func (p *printer) binary(left ast.Expression, operator string, right ast.Expression) {
+	prec := parser.Precedence(token.TokenType(operator))
+	p.operand(left, precedence(left) < prec)
+	p.print(" " + operator + " ")
+	p.operand(right, precedence(right) <= prec)
+}
+
+func (p *printer) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		p.print(exp.Value)
+	case *ast.IntegerLiteral:
+		p.print(integerLiteral(exp))
+	case *ast.FloatLiteral:
+		p.print(floatLiteral(exp))
+	case *ast.Boolean:
+		p.print(strconv.FormatBool(exp.Value))
+	case *ast.StringLiteral:
+		p.print(p.stringLiteral(exp))
+	case *ast.PrefixExpression:
+		// -(-1) keeps its parentheses, the operators would run together
+		inner, same := exp.Right.(*ast.PrefixExpression)
+		same = same && inner.Operator == exp.Operator
+		p.print(exp.Operator)
+		p.operand(exp.Right, same || precedence(exp.Right) < parser.PREFIX)
+	case *ast.InfixExpression:
+		p.binary(exp.Left, exp.Operator, exp.Right)
+	case *ast.LogicalExpression:
+		p.binary(exp.Left, exp.Operator, exp.Right)
+	case *ast.AssignExpression:
+		// assignments group to the right
+		p.expression(exp.Target)
+		p.print(" " + exp.Operator + " ")
+		p.operand(exp.Value, precedence(exp.Value) < parser.ASSIGN)
+	case *ast.IfExpression:
+		p.compound(func(q *printer, oneLine bool) { q.ifExpression(exp, oneLine) })
+	case *ast.MatchExpression:
+		p.print("match (")
+		p.expression(exp.Subject)
+		p.print(") {")
+		if len(exp.Arms) == 0 {
+			p.emptyBody(exp)
+			p.print("}")
+			return
+		}
+		arms := make([]ast.Node, len(exp.Arms))
+		for i, arm := range exp.Arms {
+			arms[i] = arm
+		}
+		p.indent++
+		p.newline()
+		p.lines(arms, func(i int) { p.matchArm(exp.Arms[i]) })
+		p.indent--
+		p.newline()
+		p.print("}")
+	case *ast.TryExpression:
+		p.compound(func(q *printer, oneLine bool) { q.tryExpression(exp, oneLine) })
+	case *ast.FunctionLiteral:
+		p.print("fn")
+		p.list("(", ")", patternNodes(exp.Parameters), func(q *printer, i int) {
+			q.pattern(exp.Parameters[i])
+		})
+		p.print(" ")
+		p.block(exp.Body)
+	case *ast.MacroLiteral:
+		names := make([]string, len(exp.Parameters))
+		for i, param := range exp.Parameters {
+			names[i] = param.Value
+		}
+		p.print("macro(" + strings.Join(names, ", ") + ") ")
+		p.block(exp.Body)
+	case *ast.CallExpression:
+		p.operand(exp.Function, precedence(exp.Function) < parser.CALL)
+		p.list("(", ")", expressionNodes(exp.Arguments), func(q *printer, i int) {
+			q.expression(exp.Arguments[i])
+		})
+	case *ast.NamedArgument:
+		p.print(exp.Name.Value + ": ")
+		p.expression(exp.Value)
+	case *ast.SpreadExpression:
+		p.print("...")
+		p.expression(exp.Value)
+	case *ast.IndexExpression:
+		p.operand(exp.Left, precedence(exp.Left) < parser.INDEX)
+		p.print("[")
+		p.expression(exp.Index)
+		p.print("]")
+	case *ast.ArrayLiteral:
+		item := func(q *printer, i int) { q.expression(exp.Elements[i]) }
+		if literals(exp.Elements) {
+			p.fill("[", "]", expressionNodes(exp.Elements), item)
+			return
+		}
+		p.list("[", "]", expressionNodes(exp.Elements), item)
+	case *ast.HashLiteral:
+		// the comments of a pair are the ones of its value
+		keys := exp.Keys()
+		values := make([]ast.Node, len(keys))
+		for i, key := range keys {
+			values[i] = exp.Pairs[key]
+		}
+		p.list("{", "}", values, func(q *printer, i int) {
+			q.expression(keys[i])
+			q.print(": ")
+			q.expression(exp.Pairs[keys[i]])
+		})
+	default:
+		p.print(exp.String())
+	}
+}
+
+// the blocks of an if or a try are all on one line or none is
+func (p *printer) compound(print func(q *printer, oneLine bool)) {
+	q := p.sub(true)
+	print(q, true)
+	if q.fits() {
+		p.write(q)
+		return
+	}
+	print(p, false)
+}
+
+// `else if` is an alternative holding just the next if expression
+func (p *printer) ifExpression(exp *ast.IfExpression, oneLine bool) {
+	p.print("if (")
+	p.expression(exp.Condition)
+	p.print(") ")
+	p.blockLines(exp.Consequence, oneLine)
+
+	alt := exp.Alternative
+	if alt == nil {
+		return
+	}
+	if alt.Token.Type == token.IF && len(alt.Statements) == 1 {
+		if es, ok := alt.Statements[0].(*ast.ExpressionStatement); ok {
+			if nested, ok := es.Expression.(*ast.IfExpression); ok {
+				p.print(" else ")
+				p.ifExpression(nested, oneLine)
+				return
+			}
+		}
+	}
+	p.print(" else ")
+	p.blockLines(alt, oneLine)
+}
+
+func (p *printer) tryExpression(exp *ast.TryExpression, oneLine bool) {
+	p.print("try ")
+	p.blockLines(exp.Block, oneLine)
+	if exp.Catch != nil {
+		p.print(" catch (" + exp.Param.Value + ") ")
+		p.blockLines(exp.Catch, oneLine)
+	}
+	if exp.Finally != nil {
+		p.print(" finally ")
+		p.blockLines(exp.Finally, oneLine)
+	}
+}
+
+// a hash literal as the body of an arm needs parentheses, `{` would start a block
+func (p *printer) matchArm(arm *ast.MatchArm) {
+	p.pattern(arm.Pattern)
+	if arm.Guard != nil {
+		p.print(" when ")
+		p.expression(arm.Guard)
+	}
+	p.print(" => ")
+
+	switch body := arm.Body.(type) {
+	case *ast.BlockStatement:
+		p.block(body)
+	case *ast.ExpressionStatement:
+		_, isHash := body.Expression.(*ast.HashLiteral)
+		p.operand(body.Expression, isHash)
+		p.print(",")
+	}
+}
+
+func integerLiteral(il *ast.IntegerLiteral) string {
+	if il.Token.Literal != "" {
+		return il.Token.Literal
+	}
+	if il.Big != nil {
+		return il.Big.String()
+	}
+	return strconv.FormatInt(il.Value, 10)
+}
+
+// a float keeps a "." or an exponent, so it is read back as a float
+func floatLiteral(fl *ast.FloatLiteral) string {
+	if fl.Token.Literal != "" {
+		return fl.Token.Literal
+	}
+	s := strconv.FormatFloat(fl.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") && !math.IsInf(fl.Value, 0) && !math.IsNaN(fl.Value) {
+		s += ".0"
+	}
+	return s
+}
+
+// a string is written the way it is in the source, a raw or a """ one too,
+// one without a source gets quotes and escape sequences
+func (p *printer) stringLiteral(sl *ast.StringLiteral) string {
+	pos, end := sl.Token.Pos, sl.Token.End
+	if sl.Token.Type == token.STRING && pos.IsValid() && end.IsValid() && end.Offset <= len(p.src) {
+		source := p.src[pos.Offset:end.Offset]
+		if value, err := lexer.Unquote(source); err == nil && value == sl.Value {
+			return source
+		}
+	}
+	return lexer.Quote(sl.Value)
+}
+
+func (p *printer) patterns(patterns []ast.Pattern, sep string) {
+	for i, pattern := range patterns {
+		if i > 0 {
+			p.print(sep)
+		}
+		p.pattern(pattern)
+	}
+}
+
+func (p *printer) pattern(pattern ast.Pattern) {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		p.print("_")
+	case *ast.BindingPattern:
+		p.print(pattern.Name.Value)
+	case *ast.LiteralPattern:
+		p.expression(pattern.Value)
+	case *ast.DefaultPattern:
+		p.pattern(pattern.Pattern)
+		p.print(" = ")
+		p.expression(pattern.Default)
+	case *ast.RestPattern:
+		p.print("..." + pattern.Name.Value)
+	case *ast.AlternativePattern:
+		p.patterns(pattern.Alternatives, " | ")
+	case *ast.ArrayPattern:
+		p.print("[")
+		p.patterns(pattern.Elements, ", ")
+		p.print("]")
+	case *ast.HashPattern:
+		p.print("{")
+		for i, key := range pattern.Keys {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.hashPatternEntry(key, pattern.Values[i])
+		}
+		p.print("}")
+	default:
+		p.print(pattern.String())
+	}
+}
+
+// `{name}` is short for `{name: name}`, and `{name = 1}` for `{name: name = 1}`
+func (p *printer) hashPatternEntry(key ast.Expression, value ast.Pattern) {
+	name, isName := key.(*ast.StringLiteral)
+	isName = isName && name.Token.Type == token.IDENT
+
+	if isName {
+		binding := value
+		if dp, ok := value.(*ast.DefaultPattern); ok {
+			binding = dp.Pattern
+		}
+		if bp, ok := binding.(*ast.BindingPattern); ok && bp.Name.Value == name.Value {
+			p.pattern(value)
+			return
+		}
+		p.print(name.Value)
+	} else {
+		p.expression(key)
+	}
+	p.print(": ")
+	p.pattern(value)
+}
+
+// the nodes of a list, for their comments
+func expressionNodes(exps []ast.Expression) []ast.Node {
+	nodes := make([]ast.Node, len(exps))
+	for i, exp := range exps {
+		nodes[i] = exp
+	}
+	return nodes
+}
+
+func patternNodes(patterns []ast.Pattern) []ast.Node {
+	nodes := make([]ast.Node, len(patterns))
+	for i, pattern := range patterns {
+		nodes[i] = pattern
+	}
+	return nodes
+}
+
+// numbers, strings and booleans, an array of them fills its lines
+func literals(exps []ast.Expression) bool {
+	for _, exp := range exps {
+		switch exp.(type) {
+		case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.Boolean:
+		case *ast.PrefixExpression:
+			if _, ok := exp.(*ast.PrefixExpression).Right.(*ast.IntegerLiteral); !ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,10 @@
 package lexer
 
-import "lexer-parser/token"
+import (
+	"lexer-parser/token"
+	"strings"
+	"unicode/utf8"
+)
 
 // A Lexer
 // just in time parse the input content to a series of tokens
@@ -9,25 +13,45 @@ type Lexer struct {
 	position     int    // current position in input (points to current char)
 	readPosition int    // current reading position in input (after current char)
 	ch           byte   // current char under examination
+	line         int    // line of the current char, starting at 1
+	column       int    // column of the current char in characters, starting at 1
+	comments     bool   // hand out the comments as COMMENT tokens instead of skipping them
 }
 
 // Parser input string into a set of tokens
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// NewWithComments is New for tools keeping the comments, like a formatter
+func NewWithComments(input string) *Lexer {
+	l := New(input)
+	l.comments = true
+	return l
+}
+
 // set the  ch point at the next character
 // set the  position point at current position
 // next readPosition += 1
 func (l *Lexer) readChar() {
+	// moving past a newline starts a new line
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+
 	// if the next character is none set
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
 		l.ch = l.input[l.readPosition]
 	}
+	// columns count characters, the other bytes of a multibyte one take none
+	if utf8.RuneStart(l.ch) {
+		l.column += 1
+	}
 	l.position = l.readPosition
 	l.readPosition += 1
 }
@@ -38,8 +62,20 @@ func (l *Lexer) NextToken() token.Token {
 	// contain a literal-type and the literal-string
 	var tok token.Token
 
-	// skip the front useless character
+	// skip the front useless character, and the comments unless they are kept
 	l.skipWhitespace()
+	for l.ch == '/' && (l.peekChar() == '/' || l.peekChar() == '*') {
+		start := l.pos()
+		tok = l.readComment()
+		tok.Pos, tok.End = start, l.pos()
+		if l.comments || tok.Type == token.ILLEGAL {
+			return tok
+		}
+		l.skipWhitespace()
+	}
+
+	// every token remembers where it starts and ends
+	start := l.pos()
 
 	switch l.ch {
 	case '=':
@@ -49,14 +85,30 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: literal}
+		} else if l.peekChar() == '>' {
+			// handle "=>"
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		// handle "+="
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: "+="}
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		// handle "-="
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: "-="}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		// handle "!="
 		if l.peekChar() == '=' {
@@ -68,13 +120,55 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		// handle "/="
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: "/="}
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		// handle "*="
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: "*="}
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		// handle "<="
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: "<="}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		// handle ">="
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: ">="}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		// handle "&&", a single "&" is not an operator
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
+	case '|':
+		// handle "||"
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: "||"}
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case '(':
@@ -87,9 +181,10 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
-	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+	case '"', '`':
+		tok = l.readString()
+		tok.Pos, tok.End = start, l.pos()
+		return tok
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -97,27 +192,42 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case 0:
+		// EOF is an empty token, so it starts and ends at the same place
 		tok.Literal = ""
 		tok.Type = token.EOF
+		tok.Pos, tok.End = start, start
+		return tok
 	default:
 		if isLetter(l.ch) {
 			// handle if the function keyword such as "let", "fn", and so on.
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Pos, tok.End = start, l.pos()
 			return tok
-		} else if isDigit(l.ch) {
-			// handle some numeric character such as "4", "10"
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+		} else if isDigit(l.ch) || l.ch == '.' && isDigit(l.peekChar()) {
+			// handle some numeric character such as "4", "10", "3.14"
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos, tok.End = start, l.pos()
 			return tok
+		} else if strings.HasPrefix(l.input[l.position:], "...") {
+			// handle "..."
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
 		}
 	}
 	l.readChar()
+	tok.Pos, tok.End = start, l.pos()
 	return tok
 }
 
+// the position of the current char under examination
+func (l *Lexer) pos() token.Position {
+	return token.Position{Offset: l.position, Line: l.line, Column: l.column}
+}
+
 // does exactly what its name suggests: it reads in an identifier and advances
 // our lexer’s positions until it encounters a non-letter-character
 func (l *Lexer) readIdentifier() string {
@@ -130,13 +240,74 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-// Similar as parsing letter
-func (l *Lexer) readNumber() string {
+// Similar as parsing letter, a fraction or an exponent makes it a float.
+// The "." and "e" are only taken when digits follow, so `1.` is an integer and a "."
+func (l *Lexer) readNumber() (string, token.TokenType) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
+
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if (l.ch == 'e' || l.ch == 'E') && l.exponentFollows() {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+	return l.input[position:l.position], tokenType
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
+}
+
+// whether the "e" under examination starts an exponent like "e9", "e-9"
+func (l *Lexer) exponentFollows() bool {
+	next := l.readPosition
+	if next < len(l.input) && (l.input[next] == '+' || l.input[next] == '-') {
+		next++
+	}
+	return next < len(l.input) && isDigit(l.input[next])
+}
+
+// read `// ...` up to the end of the line, or `/* ... */` which can be nested.
+// A block comment left open is ILLEGAL
+func (l *Lexer) readComment() token.Token {
+	position := l.position
+
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		literal := strings.TrimRight(l.input[position:l.position], "\r")
+		return token.Token{Type: token.COMMENT, Literal: literal}
+	}
+
+	depth := 0
+	for {
+		switch {
+		case l.ch == 0:
+			return token.Token{Type: token.ILLEGAL, Literal: l.input[position:l.position]}
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+		if depth == 0 {
+			return token.Token{Type: token.COMMENT, Literal: l.input[position:l.position]}
+		}
+	}
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -166,14 +337,3 @@ func isLetter(ch byte) bool {
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
-
-func (l *Lexer) readString() string {
-	position := l.position + 1
-	for {
-		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
-		}
-	}
-	return l.input[position:l.position]
-}
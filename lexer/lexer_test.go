@@ -6,6 +6,7 @@ import (
 )
 
 func TestNextToken(t *testing.T) {
+	// "/*" would start a comment, the slash and the star are apart
 	input := `
 		let five = 5;
 		let ten = 10;
@@ -13,7 +14,7 @@ func TestNextToken(t *testing.T) {
 			x + y;
 		};
 		let result = add(five, ten);
-		!-/*5;
+		!-/ *5;
 		5 < 10 > 5;
 		if (5 < 10) {
 			return true;
@@ -134,3 +135,290 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  add(x, \"hi\")"
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedPos    token.Position
+		expectedEndPos token.Position
+	}{
+		{token.LET, token.Position{Offset: 0, Line: 1, Column: 1}, token.Position{Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Offset: 4, Line: 1, Column: 5}, token.Position{Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Offset: 6, Line: 1, Column: 7}, token.Position{Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Offset: 8, Line: 1, Column: 9}, token.Position{Offset: 9, Line: 1, Column: 10}},
+		{token.SEMICOLON, token.Position{Offset: 9, Line: 1, Column: 10}, token.Position{Offset: 10, Line: 1, Column: 11}},
+		{token.IDENT, token.Position{Offset: 13, Line: 2, Column: 3}, token.Position{Offset: 16, Line: 2, Column: 6}},
+		{token.LPAREN, token.Position{Offset: 16, Line: 2, Column: 6}, token.Position{Offset: 17, Line: 2, Column: 7}},
+		{token.IDENT, token.Position{Offset: 17, Line: 2, Column: 7}, token.Position{Offset: 18, Line: 2, Column: 8}},
+		{token.COMMA, token.Position{Offset: 18, Line: 2, Column: 8}, token.Position{Offset: 19, Line: 2, Column: 9}},
+		{token.STRING, token.Position{Offset: 20, Line: 2, Column: 10}, token.Position{Offset: 24, Line: 2, Column: 14}},
+		{token.RPAREN, token.Position{Offset: 24, Line: 2, Column: 14}, token.Position{Offset: 25, Line: 2, Column: 15}},
+		{token.EOF, token.Position{Offset: 25, Line: 2, Column: 15}, token.Position{Offset: 25, Line: 2, Column: 15}},
+	}
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("test[%d] - tokenType wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Errorf("test[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End != tt.expectedEndPos {
+			t.Errorf("test[%d] - end wrong. expected=%+v, got=%+v", i, tt.expectedEndPos, tok.End)
+		}
+	}
+
+	// a character of several bytes takes one column
+	l = New(`"é😀" + x`)
+	l.NextToken()
+	l.NextToken()
+	if tok := l.NextToken(); tok.Pos != (token.Position{Offset: 11, Line: 1, Column: 8}) {
+		t.Errorf("wrong position after multibyte characters. got=%+v", tok.Pos)
+	}
+}
+
+func TestTwoCharacterOperators(t *testing.T) {
+	input := `a <= b >= c && d || e & f | g += 1 -= 2 *= 3 /= 4`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.ILLEGAL, "&"},
+		{token.IDENT, "f"},
+		{token.PIPE, "|"},
+		{token.IDENT, "g"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	input := `while for in break continue inside match when => matches throw try catch finally macro`
+
+	expected := []token.TokenType{
+		token.WHILE, token.FOR, token.IN, token.BREAK, token.CONTINUE, token.IDENT,
+		token.MATCH, token.WHEN, token.ARROW, token.IDENT,
+		token.THROW, token.TRY, token.CATCH, token.FINALLY, token.MACRO, token.EOF,
+	}
+
+	l := New(input)
+
+	for i, tt := range expected {
+		tok := l.NextToken()
+
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 1e-9 2E+3 .5 1.e 7.foo 10e % 2`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2E+3"},
+		{token.FLOAT, ".5"},
+		// a "." or "e" without digits is not part of the number
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "e"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "foo"},
+		{token.INT, "10"},
+		{token.IDENT, "e"},
+		{token.PERCENT, "%"},
+		{token.INT, "2"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// a line comment
+let x = 5; // after x
+/* a block
+   comment */ x /= 2 / 1;
+/* nested /* block */ comment */ x`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.COMMENT, "// a line comment"},
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "// after x"},
+		{token.COMMENT, "/* a block\n   comment */"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SLASH, "/"},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.COMMENT, "/* nested /* block */ comment */"},
+		{token.IDENT, "x"},
+		{token.EOF, ""},
+	}
+
+	// they are skipped unless they are kept
+	skipped := New(input)
+	kept := NewWithComments(input)
+
+	for i, tt := range tests {
+		tok := kept.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tt.expectedType == token.COMMENT {
+			continue
+		}
+		tok = skipped.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token without comments. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	// a comment knows where it is
+	l := NewWithComments("x /* c */")
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Pos != (token.Position{Offset: 2, Line: 1, Column: 3}) || tok.End != (token.Position{Offset: 9, Line: 1, Column: 10}) {
+		t.Errorf("wrong position. got=%v-%v", tok.Pos, tok.End)
+	}
+
+	// a block comment left open is illegal, kept or not
+	for _, l := range []*Lexer{New("x /* open"), NewWithComments("x /* open")} {
+		l.NextToken()
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL || tok.Literal != "/* open" {
+			t.Errorf("expected ILLEGAL \"/* open\", got=%s %q", tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"a\nb\tc"`, token.STRING, "a\nb\tc"},
+		{`"say \"hi\" \\ bye\r"`, token.STRING, "say \"hi\" \\ bye\r"},
+		{`"\u{1F600} \u{e9}"`, token.STRING, "\U0001F600 \u00e9"},
+		{`""`, token.STRING, ""},
+		{"`raw \\n\n  \"lines\"`", token.STRING, "raw \\n\n  \"lines\""},
+		{"\"\"\"\n    first\n      second \\u{21}\n\n    \"\"\"", token.STRING, "first\n  second !\n"},
+		{`"""say "hi" """`, token.STRING, `say "hi" `},
+		// broken strings are illegal, with their source as literal
+		{`"open`, token.ILLEGAL, `"open`},
+		{`"escaped end\"`, token.ILLEGAL, `"escaped end\"`},
+		{"`open\n", token.ILLEGAL, "`open\n"},
+		{`"""open"" `, token.ILLEGAL, `"""open"" `},
+		{`"a\qb"`, token.ILLEGAL, `"a\qb"`},
+		{`"\u{110000}"`, token.ILLEGAL, `"\u{110000}"`},
+		{`"\u1F600"`, token.ILLEGAL, `"\u1F600"`},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		// the string ends where its source does
+		if tok.Type == token.STRING && tok.End.Offset != len(tt.input) {
+			t.Errorf("tests[%d] - wrong end. expected=%d, got=%d", i, len(tt.input), tok.End.Offset)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"open`, "string not terminated"},
+		{`"a\qb"`, `unknown escape sequence \q`},
+		{`"\u{D800}"`, `invalid unicode code point \u{D800}`},
+		{`"a" + "b"`, `"\"a\" + \"b\"" is more than a string`},
+	}
+
+	for _, tt := range tests {
+		_, err := Unquote(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. expected=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// Quote gives a string Unquote reads back
+	for _, s := range []string{"plain", "a\nb\tc\r", `"quoted" \ back`, "\x00\x1b\x7f", "\U0001F600"} {
+		value, err := Unquote(Quote(s))
+		if err != nil || value != s {
+			t.Errorf("Quote(%q) = %s is read back as %q, %v", s, Quote(s), value, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"lexer-parser/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var errUnterminated = errors.New("string not terminated")
+
+// read a string literal: "..." with escape sequences, `...` taken as it is,
+// or """...""" with escape sequences and the indentation of its lines stripped.
+// The literal of the token is the value of the string, a string left open
+// or with a wrong escape sequence is ILLEGAL with its source as literal
+func (l *Lexer) readString() token.Token {
+	position := l.position
+
+	n := stringEnd(l.input[position:])
+	if n < 0 {
+		n = len(l.input) - position
+	}
+	for i := 0; i < n; i++ {
+		l.readChar()
+	}
+
+	source := l.input[position:l.position]
+	value, err := Unquote(source)
+	if err != nil {
+		return token.Token{Type: token.ILLEGAL, Literal: source}
+	}
+	return token.Token{Type: token.STRING, Literal: value}
+}
+
+// the length of the string literal s starts with, -1 when it is not closed
+func stringEnd(s string) int {
+	quote := s[:1]
+	if strings.HasPrefix(s, `"""`) {
+		quote = `"""`
+	}
+
+	for i := len(quote); i < len(s); i++ {
+		if s[i] == '\\' && quote != "`" {
+			i++
+			continue
+		}
+		if strings.HasPrefix(s[i:], quote) {
+			return i + len(quote)
+		}
+	}
+	return -1
+}
+
+// Unquote returns the value of the string literal s, the way the lexer reads it
+func Unquote(s string) (string, error) {
+	if s == "" || s[0] != '"' && s[0] != '`' {
+		return "", fmt.Errorf("%q is not a string", s)
+	}
+	n := stringEnd(s)
+	if n < 0 {
+		return "", errUnterminated
+	}
+	if n != len(s) {
+		return "", fmt.Errorf("%q is more than a string", s)
+	}
+
+	switch {
+	case s[0] == '`':
+		return s[1 : n-1], nil
+	case strings.HasPrefix(s, `"""`):
+		return unescape(trimIndent(s[3 : n-3]))
+	default:
+		return unescape(s[1 : n-1])
+	}
+}
+
+// the lines between """ and """ without the indentation they share,
+// the first and the last one are left out when there is nothing on them
+func trimIndent(s string) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) == 1 {
+		return s
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, "\r")
+	}
+	if strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	indent, first := "", true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lineIndent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			indent, first = lineIndent, false
+		}
+		for !strings.HasPrefix(lineIndent, indent) {
+			indent = indent[:len(indent)-1]
+		}
+	}
+
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = line[len(indent):]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// replace the escape sequences: \n \t \r \\ \" and \u{1F600}
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errUnterminated
+		}
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case 'r':
+			out.WriteByte('\r')
+		case '\\', '"':
+			out.WriteByte(s[i])
+		case 'u':
+			end := strings.IndexByte(s[i:], '}')
+			if !strings.HasPrefix(s[i:], "u{") || end < 0 {
+				return "", errors.New(`a unicode escape sequence is written \u{1F600}`)
+			}
+			digits := s[i+2 : i+end]
+			code, err := strconv.ParseUint(digits, 16, 32)
+			if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf(`invalid unicode code point \u{%s}`, digits)
+			}
+			out.WriteRune(rune(code))
+			i += end
+		default:
+			r, _ := utf8.DecodeRuneInString(s[i:])
+			return "", fmt.Errorf(`unknown escape sequence \%c`, r)
+		}
+	}
+	return out.String(), nil
+}
+
+// Quote returns a "..." string literal of s, the one Unquote turns back into s
+func Quote(s string) string {
+	var out strings.Builder
+	out.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '\n':
+			out.WriteString(`\n`)
+		case r == '\t':
+			out.WriteString(`\t`)
+		case r == '\r':
+			out.WriteString(`\r`)
+		case r == '\\' || r == '"':
+			out.WriteByte('\\')
+			out.WriteRune(r)
+		case r < ' ' || r == 0x7f:
+			fmt.Fprintf(&out, `\u{%X}`, r)
+		default:
+			out.WriteRune(r)
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
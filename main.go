@@ -3,8 +3,10 @@ package main
 import (
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"lexer-parser/evaluator"
 	"lexer-parser/repl"
 	"net/http"
 	"time"
@@ -12,12 +14,20 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+var engine = flag.String("engine", "eval", "the engine running the programs: eval, or vm which refuses the programs going beyond the core language")
+var timeout = flag.Duration("timeout", 2*time.Second, "how long a program may run")
+var maxSteps = flag.Int64("max-steps", 10000000, "how many steps a program may take in the evaluator, 0 for no limit")
+var maxDepth = flag.Int("max-depth", evaluator.DefaultMaxDepth, "how deep function calls may nest in the evaluator")
+var strict = flag.Bool("strict", false, "make destructuring a missing element or key an error instead of null")
+
 func main() {
+	flag.Parse()
+
 	r := gin.Default()
 	r.POST("/code", func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(time.Microsecond * 900))
+		// the program is stopped when it runs too long or the client goes away
+		ctx, cancel := context.WithTimeout(c.Request.Context(), *timeout)
 		defer cancel()
-		timer := time.NewTimer(time.Duration(time.Microsecond * 2000))
 
 		buf := make([]byte, 1024)
 		n, _ := c.Request.Body.Read(buf)
@@ -25,31 +35,19 @@ func main() {
 		raw_code := string(buf[0:n])
 		fmt.Println("body: ", raw_code)
 
-		channel := make(chan string, 1)
-		check := make(chan bool, 1)
-
-		go func(ctx context.Context) {
-			res, ok := repl.StartHandle(raw_code)
-			channel <- res
-			check <- ok
-		}(ctx)
+		ret, ok := repl.StartHandle(ctx, raw_code, repl.Engine(*engine), evaluator.Limits{MaxSteps: *maxSteps, MaxDepth: *maxDepth, Strict: *strict})
+		fmt.Println("Response: ", ret)
 
-		select {
-		case <-ctx.Done():
-			ret := <-channel
-			fmt.Println("Response: ", ret)
-			check_ok := <-check
-			if check_ok {
-				c.JSON(http.StatusOK, ret)
-			} else {
-				c.JSON(http.StatusNotAcceptable, ret)
-			}
-			return
-		case <-timer.C:
+		if ctx.Err() != nil {
 			fmt.Println("Handle Timeout")
 			c.JSON(http.StatusNotAcceptable, "Program RunTimeout")
 			return
 		}
+		if ok {
+			c.JSON(http.StatusOK, ret)
+		} else {
+			c.JSON(http.StatusNotAcceptable, ret)
+		}
 	})
 	r.Run(":8888")
 }
@@ -62,5 +60,5 @@ func main() {
 
 // fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
 // fmt.Printf("Typing Commands\n")
-// repl.Start(os.Stdin, os.Stdout)
+// repl.Start(os.Stdin, os.Stdout, repl.Engine(*engine))
 // }
@@ -0,0 +1,227 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Builtins is shared by the evaluator and the vm, the vm refers to a builtin by its index
+// so new builtins have to be appended at the end.
+// A builtin returns nil when its result is null.
+var Builtins = []struct {
+	Name    string
+	Builtin *Builtin
+}{
+	{
+		"len",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			case *String:
+				return &Integer{Value: int64(len(arg.Value))}
+			default:
+				return newKindError(TypeError, "argument to `len` not supported, got %s", args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		"puts",
+		&Builtin{Fn: func(args ...Object) Object {
+			for _, arg := range args {
+				fmt.Println(arg.Inspect())
+			}
+			return nil
+		},
+		},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newKindError(TypeError, "argument to `first` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array)
+			if len(arr.Elements) > 0 {
+				return arr.Elements[0]
+			}
+			return nil
+		},
+		},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newKindError(TypeError, "argument to `last` must be ARRAY, got %s",
+					args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if length > 0 {
+				return arr.Elements[length-1]
+			}
+			return nil
+		},
+		},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=1",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newKindError(TypeError, "argument to `rest` must be ARRAY, got %s",
+					args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			if length > 0 {
+				newElements := make([]Object, length-1)
+				copy(newElements, arr.Elements[1:length])
+				return &Array{Elements: newElements}
+			}
+			return nil
+		},
+		},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 2 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			if args[0].Type() != ARRAY_OBJ {
+				return newKindError(TypeError, "argument to `push` must be ARRAY, got %s",
+					args[0].Type())
+			}
+			arr := args[0].(*Array)
+			length := len(arr.Elements)
+			newElements := make([]Object, length+1)
+			copy(newElements, arr.Elements)
+			newElements[length] = args[1]
+			return &Array{Elements: newElements}
+		},
+		},
+	},
+	{
+		// int(x) truncates floats toward zero and parses strings
+		"int",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer, *BigInt:
+				return arg
+			case *Float:
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newKindError(ArgumentError, "cannot convert %s to INTEGER", arg.Inspect())
+				}
+				value, _ := big.NewFloat(arg.Value).Int(nil)
+				return NewInteger(value)
+			case *String:
+				value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 0)
+				if !ok {
+					return newKindError(ArgumentError, "cannot convert %q to INTEGER", arg.Value)
+				}
+				return NewInteger(value)
+			default:
+				return newKindError(TypeError, "argument to `int` not supported, got %s", args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		"float",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) != 1 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Integer:
+				return &Float{Value: float64(arg.Value)}
+			case *BigInt:
+				value, _ := new(big.Float).SetInt(arg.Value).Float64()
+				return &Float{Value: value}
+			case *Float:
+				return arg
+			case *String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newKindError(ArgumentError, "cannot convert %q to FLOAT", arg.Value)
+				}
+				return &Float{Value: value}
+			default:
+				return newKindError(TypeError, "argument to `float` not supported, got %s", args[0].Type())
+			}
+		},
+		},
+	},
+	{
+		// range(end), range(start, end) or range(start, end, step)
+		"range",
+		&Builtin{Fn: func(args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newKindError(ArgumentError, "wrong number of arguments. got=%d, want=1..3", len(args))
+			}
+			bounds := []int64{}
+			for _, arg := range args {
+				i, ok := arg.(*Integer)
+				if !ok {
+					return newKindError(TypeError, "argument to `range` must be INTEGER, got %s", arg.Type())
+				}
+				bounds = append(bounds, i.Value)
+			}
+
+			r := &Range{Start: 0, Step: 1}
+			switch len(bounds) {
+			case 1:
+				r.End = bounds[0]
+			case 2:
+				r.Start, r.End = bounds[0], bounds[1]
+			case 3:
+				r.Start, r.End, r.Step = bounds[0], bounds[1], bounds[2]
+			}
+			if r.Step == 0 {
+				return newKindError(ArgumentError, "`range` step must not be 0")
+			}
+			return r
+		},
+		},
+	},
+}
+
+// look a builtin up by the name Monkey code calls it with
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			return def.Builtin
+		}
+	}
+	return nil
+}
+
+// wrong argument counts are ArgumentErrors, arguments of the wrong type TypeErrors
+// and ones of the right type a builtin cannot take, like int("x"), ArgumentErrors too
+func newKindError(kind ErrorKind, format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...), Kind: kind}
+}
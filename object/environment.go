@@ -1,12 +1,25 @@
 package object
 
+import "errors"
+
+var (
+	ErrUndeclared = errors.New("undeclared identifier")
+	ErrConstant   = errors.New("constant")
+)
+
+// a value bound to a name, constants cannot be bound again
+type binding struct {
+	value    Object
+	constant bool
+}
+
 type Environment struct {
-	store map[string]Object
+	store map[string]binding
 	outer *Environment
 }
 
 func NewEnvironment() *Environment {
-	s := make(map[string]Object)
+	s := make(map[string]binding)
 	return &Environment{store: s, outer: nil}
 }
 
@@ -18,15 +31,42 @@ func NewEnclosedEnvironment(outer *Environment) *Environment {
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
-	obj, ok := e.store[name]
+	b, ok := e.store[name]
 	// checks the enclosing environment for the given name
 	if !ok && e.outer != nil {
-		obj, ok = e.outer.Get(name)
+		return e.outer.Get(name)
 	}
-	return obj, ok
+	return b.value, ok
 }
 
+// bind name in this environment, whatever it was bound to before
 func (e *Environment) Set(name string, val Object) Object {
-	e.store[name] = val
+	e.store[name] = binding{value: val}
 	return val
 }
+
+// bind name in this environment like let or const do,
+// it fails with ErrConstant when name is already a constant here
+func (e *Environment) Define(name string, val Object, constant bool) error {
+	if b, ok := e.store[name]; ok && b.constant {
+		return ErrConstant
+	}
+	e.store[name] = binding{value: val, constant: constant}
+	return nil
+}
+
+// update the binding of name in the environment defining it. It fails with
+// ErrUndeclared when no environment does and with ErrConstant for a constant.
+func (e *Environment) Assign(name string, val Object) error {
+	if b, ok := e.store[name]; ok {
+		if b.constant {
+			return ErrConstant
+		}
+		e.store[name] = binding{value: val}
+		return nil
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return ErrUndeclared
+}
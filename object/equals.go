@@ -0,0 +1,99 @@
+package object
+
+import "math/big"
+
+// Equals reports whether a and b hold the same value.
+// Numbers are compared by value whatever their type, strings, arrays and hashes by content,
+// functions and builtins only equal themselves.
+func Equals(a, b Object) bool {
+	return equals(a, b, map[[2]Object]bool{})
+}
+
+// seen holds the pairs of arrays and hashes being compared, a pair met again
+// is part of a cycle and is taken as equal, the rest of the values decides
+func equals(a, b Object, seen map[[2]Object]bool) bool {
+	// before the identity check, NaN is not equal to itself
+	if isNumber(a) && isNumber(b) {
+		return numbersEqual(a, b)
+	}
+	if a == b {
+		return true
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *Boolean:
+		return a.Value == b.(*Boolean).Value
+	case *Null:
+		return true
+	case *String:
+		return a.Value == b.(*String).Value
+	case *Array:
+		other := b.(*Array)
+		if len(a.Elements) != len(other.Elements) {
+			return false
+		}
+		pair := [2]Object{a, other}
+		if seen[pair] {
+			return true
+		}
+		seen[pair] = true
+		for i, el := range a.Elements {
+			if !equals(el, other.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		other := b.(*Hash)
+		if len(a.Pairs) != len(other.Pairs) {
+			return false
+		}
+		pair := [2]Object{a, other}
+		if seen[pair] {
+			return true
+		}
+		seen[pair] = true
+		for key, p := range a.Pairs {
+			otherPair, ok := other.Pairs[key]
+			if !ok || !equals(p.Value, otherPair.Value, seen) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func isNumber(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInt, *Float:
+		return true
+	default:
+		return false
+	}
+}
+
+// integers are compared exactly, an integer and a float as floats
+func numbersEqual(a, b Object) bool {
+	aInt, bInt := ToBig(a), ToBig(b)
+	if aInt != nil && bInt != nil {
+		return aInt.Cmp(bInt) == 0
+	}
+	return toFloat(a) == toFloat(b)
+}
+
+func toFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	default:
+		return obj.(*Float).Value
+	}
+}
@@ -2,9 +2,16 @@ package object
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"hash/fnv"
 	"lexer-parser/ast"
+	"lexer-parser/code"
+	"lexer-parser/token"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -12,6 +19,8 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
+	BIGINT_OBJ       = "BIGINT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
@@ -21,6 +30,13 @@ const (
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	HASH_OBJ         = "HASH"
+	RANGE_OBJ        = "RANGE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+	QUOTE_OBJ        = "QUOTE"
+	MACRO_OBJ        = "MACRO"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
 )
 
 type Object interface {
@@ -35,6 +51,48 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
+// an integer too large for an Integer, arithmetic on integers promotes to it on overflow
+type BigInt struct {
+	Value *big.Int
+}
+
+func (bi *BigInt) Inspect() string  { return bi.Value.String() }
+func (bi *BigInt) Type() ObjectType { return BIGINT_OBJ }
+
+// NewInteger returns an Integer when v fits in one, a BigInt otherwise
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInt{Value: v}
+}
+
+// ToBig returns the value of an Integer or a BigInt as a big.Int
+func ToBig(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInt:
+		return obj.Value
+	default:
+		return nil
+	}
+}
+
+type Float struct {
+	Value float64
+}
+
+// always shows a fraction or an exponent, so 3.0 is not mistaken for the integer 3
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
 type Boolean struct {
 	Value bool
 }
@@ -54,17 +112,121 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// left by a break statement, it unwinds the blocks up to the loop like a ReturnValue
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+// left by a continue statement, see Break
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Kind says what went wrong, it is the "type" of a caught error. Pos is where the error was
+// raised and Stack the calls it left, innermost first, both unknown for errors of the vm.
+// Value is what a throw raised. A Fatal error, like running out of steps, stops the program
+// whatever the try expressions around it
 type Error struct {
-	Message string
+	Message string         `json:"message"`
+	Kind    ErrorKind      `json:"type"`
+	Pos     token.Position `json:"pos"`
+	Stack   []Frame        `json:"stack"`
+	Value   Object         `json:"-"`
+	Fatal   bool           `json:"fatal,omitempty"`
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+
+// the message, then where the error was raised and the calls it went through
+func (e *Error) Inspect() string {
+	var out bytes.Buffer
+
+	out.WriteString("ERROR: " + e.Message)
+	if e.Pos.IsValid() {
+		out.WriteString("\n  at " + e.Pos.String())
+	}
+	for _, f := range e.Stack {
+		out.WriteString("\n  in " + f.Trace())
+	}
+
+	return out.String()
+}
+
+// the vm reports runtime errors as Go errors, so an *Error can be returned as one
+func (e *Error) Error() string { return e.Message }
+
+type ErrorKind string
+
+const (
+	GenericError    ErrorKind = "Error" // thrown by a program
+	TypeError       ErrorKind = "TypeError"
+	NameError       ErrorKind = "NameError"
+	IndexError      ErrorKind = "IndexError"
+	ArgumentError   ErrorKind = "ArgumentError"
+	ArithmeticError ErrorKind = "ArithmeticError"
+	MatchError      ErrorKind = "MatchError"
+	RecursionError  ErrorKind = "RecursionError" // function calls nested deeper than allowed
+)
+
+// a function call an error went through. The name is the one of the let the function was
+// bound with, a tail call replaces the frame of its caller
+type Frame struct {
+	Function string
+	Pos      token.Position // where the function was called
+	Args     []Object
+}
+
+func (f Frame) String() string {
+	return fmt.Sprintf("%s (%s)", f.Function, f.Pos)
+}
+
+// the call with its arguments shortened, add(1, "two", [1, 2, 3, 4, ...])
+func (f Frame) Call() string {
+	return f.Function + "(" + strings.Join(f.arguments(), ", ") + ")"
+}
+
+// the frame as a line of a stack trace
+func (f Frame) Trace() string {
+	return f.Call() + " called at " + f.Pos.String()
+}
+
+func (f Frame) arguments() []string {
+	args := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		args[i] = summarize(arg)
+	}
+	return args
+}
+
+func (f Frame) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Function string         `json:"function"`
+		Pos      token.Position `json:"pos"`
+		Args     []string       `json:"args"`
+	}{f.Function, f.Pos, f.arguments()})
+}
+
+// how long an argument of a frame may be shown
+const maxSummary = 24
+
+func summarize(obj Object) string {
+	s := obj.Inspect()
+	if str, ok := obj.(*String); ok {
+		s = strconv.Quote(str.Value)
+	}
+	if r := []rune(s); len(r) > maxSummary {
+		s = string(r[:maxSummary-3]) + "..."
+	}
+	return s
+}
 
 type Function struct {
-	Parameters []*ast.Identifier
+	Parameters []ast.Pattern
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Name       string // the name given by `let`, empty for anonymous functions
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -86,6 +248,39 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// a piece of code, what quote(<expression>) returns
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string  { return "QUOTE(" + q.Node.String() + ")" }
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+
+	return out.String()
+}
+
 type String struct {
 	Value string
 }
@@ -102,17 +297,54 @@ type Builtin struct {
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
 
+// the integers from Start up to End (excluded) by Step, made by the range builtin.
+// They are produced one by one by for loops instead of being stored.
+type Range struct {
+	Start int64
+	End   int64
+	Step  int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
 type Array struct {
 	Elements []Object
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
+func (ao *Array) Inspect() string  { return inspect(ao, map[Object]bool{}) }
+
+// inspect shows arrays and hashes, one holding itself is shown as [...] or {...} in it.
+// seen holds the ones being shown around obj
+func inspect(obj Object, seen map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		if seen[obj] {
+			return "[...]"
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		return obj.inspect(seen)
+	case *Hash:
+		if seen[obj] {
+			return "{...}"
+		}
+		seen[obj] = true
+		defer delete(seen, obj)
+		return obj.inspect(seen)
+	}
+	return obj.Inspect()
+}
+
+func (ao *Array) inspect(seen map[Object]bool) string {
 	var out bytes.Buffer
 
 	elements := []string{}
 	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspect(e, seen))
 	}
 
 	out.WriteString("[")
@@ -143,6 +375,20 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+func (bi *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(bi.Value.String()))
+	return HashKey{Type: bi.Type(), Value: h.Sum64()}
+}
+
+func (f *Float) HashKey() HashKey {
+	// 0.0 and -0.0 are the same key
+	if f.Value == 0 {
+		return HashKey{Type: f.Type(), Value: 0}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -159,12 +405,14 @@ type Hash struct {
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return inspect(h, map[Object]bool{}) }
+
+func (h *Hash) inspect(seen map[Object]bool) string {
 	var out bytes.Buffer
 
 	pairs := []string{}
 	for _, pair := range h.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), inspect(pair.Value, seen)))
 	}
 
 	out.WriteString("{")
@@ -174,6 +422,76 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// SortedPairs returns the pairs ordered by key: numbers, then booleans, then strings,
+// each in their natural order. Hashes have no order of their own, this one is stable.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return keyLess(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func keyLess(a, b Object) bool {
+	if isNumber(a) && isNumber(b) {
+		aInt, bInt := ToBig(a), ToBig(b)
+		if aInt != nil && bInt != nil {
+			return aInt.Cmp(bInt) < 0
+		}
+		return toFloat(a) < toFloat(b)
+	}
+	if rank(a) != rank(b) {
+		return rank(a) < rank(b)
+	}
+	switch a := a.(type) {
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	default:
+		return false
+	}
+}
+
+// the order of the kinds of keys in SortedPairs
+func rank(obj Object) int {
+	switch obj.(type) {
+	case *Integer, *BigInt, *Float:
+		return 0
+	case *Boolean:
+		return 1
+	default:
+		return 2
+	}
+}
+
 type Hashable interface {
 	HashKey() HashKey
 }
+
+// a function lowered to bytecode by the compiler
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int // parameters included
+	NumParameters int
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// a compiled function together with the free variables it captured when it was created
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+// for Monkey code a closure is just a function, like in the evaluator
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	return fmt.Sprintf("Closure[%p]", c)
+}
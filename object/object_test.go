@@ -1,6 +1,11 @@
 package object
 
-import "testing"
+import (
+	"encoding/json"
+	"lexer-parser/token"
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +22,165 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{3, "3.0"},
+		{3.25, "3.25"},
+		{1e21, "1e+21"},
+		{-0.5, "-0.5"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("wrong Inspect. want=%q, got=%q", tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestEquals(t *testing.T) {
+	hash := func(pairs ...Object) *Hash {
+		h := &Hash{Pairs: map[HashKey]HashPair{}}
+		for i := 0; i < len(pairs); i += 2 {
+			h.Pairs[pairs[i].(Hashable).HashKey()] = HashPair{Key: pairs[i], Value: pairs[i+1]}
+		}
+		return h
+	}
+	fn := &Builtin{}
+
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&String{Value: "a"}, &String{Value: "b"}, false},
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{&Integer{Value: 1}, &String{Value: "1"}, false},
+		{&Float{Value: math.NaN()}, &Float{Value: math.NaN()}, false},
+		{&Null{}, &Null{}, true},
+		{
+			&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}},
+			&Array{Elements: []Object{&Integer{Value: 1}, &String{Value: "a"}}},
+			true,
+		},
+		{
+			&Array{Elements: []Object{&Integer{Value: 1}}},
+			&Array{Elements: []Object{&Integer{Value: 1}, &Integer{Value: 2}}},
+			false,
+		},
+		{
+			hash(&String{Value: "a"}, &Array{Elements: []Object{}}),
+			hash(&String{Value: "a"}, &Array{Elements: []Object{}}),
+			true,
+		},
+		{
+			hash(&String{Value: "a"}, &Integer{Value: 1}),
+			hash(&String{Value: "b"}, &Integer{Value: 1}),
+			false,
+		},
+		{fn, fn, true},
+		{fn, &Builtin{}, false},
+	}
+
+	for i, tt := range tests {
+		if Equals(tt.a, tt.b) != tt.expected {
+			t.Errorf("tests[%d] - Equals(%s, %s) wrong. want=%t", i, tt.a.Inspect(), tt.b.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestEqualsCycles(t *testing.T) {
+	a := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	a.Elements[1] = a
+	b := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	b.Elements[1] = b
+	c := &Array{Elements: []Object{&Integer{Value: 2}, nil}}
+	c.Elements[1] = c
+
+	if !Equals(a, b) {
+		t.Errorf("arrays with the same cycle are not equal")
+	}
+	if Equals(a, c) {
+		t.Errorf("arrays with different elements are equal")
+	}
+}
+
+func TestInspectCycles(t *testing.T) {
+	a := &Array{Elements: []Object{&Integer{Value: 1}, nil}}
+	a.Elements[1] = a
+	h := &Hash{Pairs: map[HashKey]HashPair{}}
+	key := &String{Value: "a"}
+	h.Pairs[key.HashKey()] = HashPair{Key: key, Value: a}
+	a.Elements = append(a.Elements, h)
+
+	if a.Inspect() != "[1, [...], {a: [...]}]" {
+		t.Errorf("wrong Inspect. got=%q", a.Inspect())
+	}
+	if h.Inspect() != "{a: [1, [...], {...}]}" {
+		t.Errorf("wrong Inspect. got=%q", h.Inspect())
+	}
+
+	// a value met twice without a cycle is shown both times
+	twice := &Array{Elements: []Object{&Array{}, nil}}
+	twice.Elements[1] = twice.Elements[0]
+	if twice.Inspect() != "[[], []]" {
+		t.Errorf("wrong Inspect. got=%q", twice.Inspect())
+	}
+
+	frame := Frame{Function: "f", Args: []Object{a}}
+	if frame.arguments()[0] != "[1, [...], {a: [...]}]" {
+		t.Errorf("wrong argument. got=%q", frame.arguments()[0])
+	}
+}
+
+func TestErrorInspect(t *testing.T) {
+	long := &Array{}
+	for i := 0; i < 20; i++ {
+		long.Elements = append(long.Elements, &Integer{Value: int64(i)})
+	}
+	err := &Error{
+		Message: "type mismatch: INTEGER + STRING",
+		Pos:     token.Position{Offset: 21, Line: 1, Column: 22},
+		Stack: []Frame{
+			{Function: "add", Pos: token.Position{Offset: 40, Line: 2, Column: 3}, Args: []Object{&Integer{Value: 1}, &String{Value: "two"}}},
+			{Function: "<anonymous>", Pos: token.Position{Offset: 60, Line: 3, Column: 1}, Args: []Object{long}},
+		},
+	}
+
+	expected := `ERROR: type mismatch: INTEGER + STRING
+  at 1:22
+  in add(1, "two") called at 2:3
+  in <anonymous>([0, 1, 2, 3, 4, 5, 6,...) called at 3:1`
+	if err.Inspect() != expected {
+		t.Errorf("wrong Inspect.\nwant=%q\ngot =%q", expected, err.Inspect())
+	}
+
+	// errors of the vm know nothing but the message
+	if vmErr := (&Error{Message: "boom"}); vmErr.Inspect() != "ERROR: boom" {
+		t.Errorf("wrong Inspect. got=%q", vmErr.Inspect())
+	}
+}
+
+func TestErrorJSON(t *testing.T) {
+	err := &Error{
+		Message: "boom",
+		Kind:    GenericError,
+		Pos:     token.Position{Offset: 0, Line: 1, Column: 1},
+		Stack:   []Frame{{Function: "f", Pos: token.Position{Offset: 4, Line: 1, Column: 5}, Args: []Object{&String{Value: "x"}}}},
+		Value:   &String{Value: "boom"},
+	}
+
+	b, e := json.Marshal(err)
+	if e != nil {
+		t.Fatalf("cannot marshal error: %v", e)
+	}
+	expected := `{"message":"boom","type":"Error","pos":{"offset":0,"line":1,"column":1},` +
+		`"stack":[{"function":"f","pos":{"offset":4,"line":1,"column":5},"args":["\"x\""]}]}`
+	if string(b) != expected {
+		t.Errorf("wrong JSON.\nwant=%s\ngot =%s", expected, b)
+	}
+}
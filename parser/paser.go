@@ -1,18 +1,24 @@
 package parser
 
 import (
-	"fmt"
+	"errors"
 	"lexer-parser/ast"
+	"lexer-parser/diagnostic"
 	"lexer-parser/lexer"
 	"lexer-parser/token"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // >, <, >= or <=
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
@@ -32,6 +38,20 @@ var precedences = map[token.TokenType]int{
 	token.LT: LESSGREATER,
 	// ">"
 	token.GT: LESSGREATER,
+	// "<="
+	token.LT_EQ: LESSGREATER,
+	// ">="
+	token.GT_EQ: LESSGREATER,
+	// "=", "+=", "-=", "*=" or "/="
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	// "&&"
+	token.AND: LOGICAL_AND,
+	// "||"
+	token.OR: LOGICAL_OR,
 	// "+"
 	token.PLUS: SUM,
 	// "-"
@@ -40,12 +60,22 @@ var precedences = map[token.TokenType]int{
 	token.SLASH: PRODUCT,
 	// "*"
 	token.ASTERISK: PRODUCT,
+	// "%"
+	token.PERCENT: PRODUCT,
 	// "("
 	token.LPAREN: CALL,
 
 	token.LBRACKET: INDEX,
 }
 
+// Precedence returns how tightly the infix operator t binds, LOWEST when t is none
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
+		return p
+	}
+	return LOWEST
+}
+
 // peek the peekToken Type for which precedence else return LOWEST
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
@@ -65,12 +95,23 @@ func (p *Parser) curPrecedence() int {
 // parse the tokens just in time
 // convert tokens -> ast nodes
 type Parser struct {
-	l      *lexer.Lexer // a pointer to an instance of the lexer
-	errors []string
+	l      *lexer.Lexer             // a pointer to an instance of the lexer
+	errors []*diagnostic.Diagnostic // the syntax errors, in the order they were found
 
 	curToken  token.Token // like in the lexer - position
 	peekToken token.Token // like in the lexer - readPosition
 
+	// set after a syntax error until the parser has skipped to the next statement,
+	// errors found meanwhile are consequences of the first one and are not reported
+	panicMode bool
+
+	braces int   // number of unclosed "{" up to and including curToken
+	blocks []int // the braces count of every block statement being parsed
+
+	loops int // number of loops around curToken in the current function, for break and continue
+
+	comments []*ast.Comment // handed out by a lexer keeping them, for Program.Comments
+
 	// prefix and infix Parser functions mapper
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -79,7 +120,7 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []*diagnostic.Diagnostic{},
 	}
 
 	// if we encounter a token of type token.
@@ -91,6 +132,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	// <integer literal>
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	// <!> <expression>
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	// <-> <expression>
@@ -102,12 +144,19 @@ func New(l *lexer.Lexer) *Parser {
 	// <(> <expression> )
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	// <if> ( <condition> ) { <consequence> } [else { alternative }]
-	// not <else if>
+	// or [else <if> ...]
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	// <match> ( <subject> ) { <pattern> => <expression>, ... }
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
+	// try
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	// <fn>
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	// macro
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	// <"> <literal> "
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 	// <[> <literal> ]
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	// <{> <literal> }
@@ -124,6 +173,8 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	// <expression> * <expression>
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	// <expression> % <expression>
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
 	// <expression> == <expression>
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	// <expression> != <expression>
@@ -132,6 +183,20 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	// <expression> > <expression>
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	// <expression> <= <expression>
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	// <expression> >= <expression>
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	// <expression> && <expression>
+	p.registerInfix(token.AND, p.parseLogicalExpression)
+	// <expression> || <expression>
+	p.registerInfix(token.OR, p.parseLogicalExpression)
+	// <target> = <expression>, and the same for +=, -=, *= and /=
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	// <expression> ( <expression> )
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	// <[> <integer literal> ]
@@ -145,14 +210,40 @@ func New(l *lexer.Lexer) *Parser {
 }
 
 // return the Parser errors[]
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []*diagnostic.Diagnostic {
 	return p.errors
 }
 
+// record a syntax error and enter panic mode, unless the parser is already recovering
+func (p *Parser) addError(d *diagnostic.Diagnostic) {
+	if p.panicMode {
+		return
+	}
+	p.errors = append(p.errors, d)
+	p.panicMode = true
+}
+
 // peek the expected token else append error to self errors[]
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.unexpectedTokenError(t, p.peekToken)
+}
+
+// the token tok showed up where a token of type t was expected
+func (p *Parser) unexpectedTokenError(t token.TokenType, tok token.Token) {
+	d := diagnostic.New(diagnostic.UnexpectedToken, tok,
+		"expected next token to be %s, got %s instead", t, tok.Type)
+	d.Expected = []token.TokenType{t}
+	if closing[t] {
+		d.WithHint("check for a missing %s", t)
+	}
+	p.addError(d)
+}
+
+// tokens that close a group; forgetting one of them is the most common syntax error
+var closing = map[token.TokenType]bool{
+	token.RPAREN:   true,
+	token.RBRACKET: true,
+	token.RBRACE:   true,
 }
 
 // judge the next token, if judgement is true then will forward a token
@@ -170,6 +261,26 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	// comments are no tokens of the grammar, they are attached to the tree in the end
+	for p.peekToken.Type == token.COMMENT {
+		p.comments = append(p.comments, &ast.Comment{Token: p.peekToken})
+		p.peekToken = p.l.NextToken()
+	}
+
+	switch {
+	case p.curTokenIs(token.LBRACE):
+		p.braces += 1
+	case p.curTokenIs(token.RBRACE) && p.braces > 0:
+		p.braces -= 1
+	}
+}
+
+// the braces count of the innermost block statement, 0 at the top level
+func (p *Parser) blockDepth() int {
+	if len(p.blocks) == 0 {
+		return 0
+	}
+	return p.blocks[len(p.blocks)-1]
 }
 
 // Construct the root node of the AST, an *ast.Program
@@ -186,22 +297,84 @@ func (p *Parser) ParseProgram() *ast.Program {
 		// forward token
 		p.nextToken()
 	}
+	if len(p.comments) > 0 {
+		program.Comments = ast.NewCommentMap(program, p.comments)
+	}
 	return program
 }
 
 // parse the statement with cases : "let", "return", other expression statement
+// a statement containing a syntax error is replaced by an *ast.BadStatement
 func (p *Parser) parseStatement() ast.Statement {
+	start := p.curToken
+
+	var stmt ast.Statement
 	switch p.curToken.Type {
 	case token.LET:
 		// let <identifier literal> = <expression>;
-		return p.parseLetStatement()
+		stmt = p.parseLetStatement()
+	case token.CONST:
+		// const <identifier literal> = <expression>;
+		stmt = p.parseLetStatement()
 	case token.RETURN:
 		// return <expression>;
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
+	case token.WHILE:
+		// while (<expression>) { <statement>* }
+		stmt = p.parseWhileStatement()
+	case token.FOR:
+		// for (<identifier> in <expression>) { <statement>* }
+		// for (<statement>; <expression>; <statement>) { <statement>* }
+		stmt = p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		stmt = p.parseLoopControlStatement()
+	case token.THROW:
+		// throw <expression>;
+		stmt = p.parseThrowStatement()
 	default:
 		// <expression>;
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
+	}
+
+	if p.panicMode {
+		return p.synchronize(start)
 	}
+	return stmt
+}
+
+// tokens which can only appear at the beginning of a statement
+var statementStart = map[token.TokenType]bool{
+	token.LET:      true,
+	token.CONST:    true,
+	token.RETURN:   true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.THROW:    true,
+}
+
+// skip the rest of a broken statement, so the parser can resume at the next one.
+// It stops on the ";" ending the statement, or before the "}" closing the enclosing block
+// or a keyword starting a new statement. Tokens nested in braces opened by the broken
+// statement itself (hash literals, function bodies) are skipped as a whole.
+func (p *Parser) synchronize(start token.Token) *ast.BadStatement {
+	depth := p.blockDepth()
+
+	for !p.curTokenIs(token.EOF) && !p.peekTokenIs(token.EOF) {
+		// the error was found on the "}" closing the enclosing block
+		if p.braces < depth {
+			break
+		}
+		if p.braces == depth && (p.curTokenIs(token.SEMICOLON) ||
+			p.peekTokenIs(token.RBRACE) || statementStart[p.peekToken.Type]) {
+			break
+		}
+		p.nextToken()
+	}
+	p.panicMode = false
+
+	return &ast.BadStatement{Token: start, To: p.curToken}
 }
 
 // when it encounters a token.LET token
@@ -210,13 +383,22 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	// initialize letStatement
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	// check next token whether is an Identifier token
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		// let [a, b] = <expression>; or let {a, b} = <expression>;
+		p.nextToken()
+		stmt.Pattern = p.parseSinglePattern()
+		if stmt.Pattern == nil || !p.checkDestructuring(stmt.Pattern) {
+			return nil
+		}
+	} else {
+		// check next token whether is an Identifier token
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 
-	// add it to the Name part
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		// add it to the Name part
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
 
 	// check next token whether is an ASSIGN("=") token
 	if !p.expectPeek(token.ASSIGN) {
@@ -229,7 +411,13 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	// parse the Expression to self Value
 	stmt.Value = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	// a function knows the name it is bound to, so it can refer to itself
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+		fl.Name = stmt.Name.Value
+	}
+
+	// the ";" is optional
+	if !p.panicMode && p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -247,8 +435,8 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	// parse the next expression to self Value
 	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	// check the current token whether is ";"
-	for !p.curTokenIs(token.SEMICOLON) {
+	// check the next token whether is ";"
+	if !p.panicMode && p.peekTokenIs(token.SEMICOLON) {
 		// skip the ";"
 		p.nextToken()
 	}
@@ -256,6 +444,152 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+// parse statement `throw <expression>;`
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if !p.panicMode && p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parse statement `while ( (Condition)<expression> ) { (Body)<BlockStatement> }`
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	return stmt
+}
+
+// parse statement `for ( <identifier> in <expression> ) { <BlockStatement> }`
+// or `for ( <statement>?; <expression>?; <statement>? ) { <BlockStatement> }`
+func (p *Parser) parseForStatement() ast.Statement {
+	forToken := p.curToken
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.IN) {
+		return p.parseForInStatement(forToken)
+	}
+
+	stmt := &ast.ForStatement{Token: forToken}
+
+	// the let and expression statements take the ";" following them
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Init = p.parseForClause()
+		if !p.curTokenIs(token.SEMICOLON) && !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	if !p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		stmt.Condition = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		stmt.Post = p.parseForClause()
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	return stmt
+}
+
+// the init and post statements of a for loop
+func (p *Parser) parseForClause() ast.Statement {
+	if p.curTokenIs(token.LET) {
+		return p.parseLetStatement()
+	}
+	return p.parseExpressionStatement()
+}
+
+// the rest of a for loop after `for ( <identifier>`
+func (p *Parser) parseForInStatement(forToken token.Token) *ast.ForInStatement {
+	stmt := &ast.ForInStatement{Token: forToken}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// skip "in"
+	p.nextToken()
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+
+	return stmt
+}
+
+// the body of a loop is where break and continue are allowed,
+// a ";" after it is optional like after an if
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	body := p.parseBlockStatement()
+	p.loops--
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return body
+}
+
+// parse statement `break;` or `continue;`
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	tok := p.curToken
+
+	if p.loops == 0 {
+		d := diagnostic.New(diagnostic.OutsideLoop, tok, "%s outside of a loop", tok.Literal)
+		p.addError(d)
+		return nil
+	}
+
+	// the ";" is optional
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	if tok.Type == token.BREAK {
+		return &ast.BreakStatement{Token: tok}
+	}
+	return &ast.ContinueStatement{Token: tok}
+}
+
 // parse statement `<expression>;'
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	// initialize expression statement
@@ -265,7 +599,7 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt.Expression = p.parseExpression(LOWEST)
 
 	// check the current token whether is ";"
-	if p.peekTokenIs(token.SEMICOLON) {
+	if !p.panicMode && p.peekTokenIs(token.SEMICOLON) {
 		// skip the ";"
 		p.nextToken()
 	}
@@ -281,6 +615,11 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	// initialize Statements array in block
 	block.Statements = []ast.Statement{}
 
+	// the current "{" is already counted, remember it for synchronize
+	depth := p.braces
+	p.blocks = append(p.blocks, depth)
+	defer func() { p.blocks = p.blocks[:len(p.blocks)-1] }()
+
 	// skip "{"
 	p.nextToken()
 
@@ -290,9 +629,19 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		stmt := p.parseStatement()
 		// append it to the series of block statements
 		block.Statements = append(block.Statements, stmt)
+		// a broken statement may already stand on the "}" closing the block
+		if p.curTokenIs(token.RBRACE) && p.braces < depth {
+			break
+		}
 		// skip current token
 		p.nextToken()
 	}
+	// remember the closing "}" so the block knows where it ends
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken
+	} else {
+		p.unexpectedTokenError(token.RBRACE, p.curToken)
+	}
 	return block
 }
 
@@ -301,12 +650,36 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	// initialize integer literals
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
-	// convert string to integer
+	// convert string to integer, a literal too large for an int64 becomes a big.Int
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if v, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			lit.Big = v
+			return lit
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		d := diagnostic.New(diagnostic.InvalidInteger, p.curToken,
+			"could not parse %q as integer", p.curToken.Literal)
+		p.addError(d)
+		return &ast.BadExpression{Token: p.curToken}
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+// parsing Float literal
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		d := diagnostic.New(diagnostic.InvalidFloat, p.curToken,
+			"could not parse %q as float", p.curToken.Literal)
+		p.addError(d)
+		return &ast.BadExpression{Token: p.curToken}
 	}
 
 	lit.Value = value
@@ -321,7 +694,7 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 
 	// check peek token whether is "("
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return &ast.BadExpression{Token: lit.Token}
 	}
 
 	// parse parameters to Self Parameters
@@ -329,44 +702,46 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 
 	// check peek token whether is "{"
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return &ast.BadExpression{Token: lit.Token}
 	}
 
-	// parse block statement to Self Body
+	// parse block statement to Self Body, the loops around the function
+	// are not the ones break and continue in its body refer to
+	loops := p.loops
+	p.loops = 0
 	lit.Body = p.parseBlockStatement()
+	p.loops = loops
 
 	return lit
 }
 
-// parse helper for parseFunctionLiteral (`<parameter:Identifier(s)>`)
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	// initialize identifiers[]
-	identifiers := []*ast.Identifier{}
+// parse helper for parseFunctionLiteral (`<parameter>, ...`), a parameter is a name
+// or a pattern destructuring the argument, possibly with a default, and the last one
+// can be `...<identifier>` for the arguments left
+func (p *Parser) parseFunctionParameters() []ast.Pattern {
+	params := []ast.Pattern{}
 
 	// check peekToken whether is ")" and skip it, meaning not parameter,
-	// return an empty identifiers[]
+	// return an empty params[]
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return params
 	}
 
-	// skip token ")"
-	p.nextToken()
+	for {
+		// skip "(" or ","
+		p.nextToken()
 
-	// meaning having a least one identifier token
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	// append the first to the identifiers[]
-	identifiers = append(identifiers, ident)
+		param := p.parsePatternElement()
+		if param == nil || !p.checkDestructuring(param) {
+			return nil
+		}
+		params = append(params, param)
 
-	// check the token "," , if exists meaning next token must be a identifier.
-	for p.peekTokenIs(token.COMMA) {
-		// skip ","
-		p.nextToken()
-		// cursor stop on the next identifier token
+		if _, ok := param.(*ast.RestPattern); ok || !p.peekTokenIs(token.COMMA) {
+			break
+		}
 		p.nextToken()
-		// initialize it ant then append to the identifiers[]
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
 	}
 
 	// check next token whether is ")"
@@ -374,7 +749,60 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		return nil
 	}
 
-	return identifiers
+	return params
+}
+
+// parse `macro(<identifier>, ...) { <statement>* }`
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	lit := &ast.MacroLiteral{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return &ast.BadExpression{Token: lit.Token}
+	}
+
+	lit.Parameters = p.parseMacroParameters()
+	if lit.Parameters == nil {
+		return &ast.BadExpression{Token: lit.Token}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return &ast.BadExpression{Token: lit.Token}
+	}
+
+	loops := p.loops
+	p.loops = 0
+	lit.Body = p.parseBlockStatement()
+	p.loops = loops
+
+	return lit
+}
+
+// the parameters of a macro are plain names, the arguments are pieces of code
+func (p *Parser) parseMacroParameters() []*ast.Identifier {
+	params := []*ast.Identifier{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return params
+	}
+
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		params = append(params, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return params
 }
 
 // handle parsing expression by the Parser,
@@ -387,7 +815,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	// if current token don't have prefix parse fn push a Parsing error
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		return &ast.BadExpression{Token: p.curToken}
 	}
 	// execute the prefix parse fn, and get the left expression
 	leftExp := prefix()
@@ -395,7 +823,8 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	// And it does all this again and
 	// again until it encounters a token that has a lower precedence.
 	// or meet the next token is ";"
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	// a broken operand ends the expression, the statement is going to be skipped anyway
+	for !p.panicMode && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		// take out the infix parse fn from prefixParseFns mapper
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
@@ -450,6 +879,47 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expression
 }
 
+// parse `<expression> && <expression>` and `<expression> || <expression>`,
+// kept apart from infix expressions since the right side is not always evaluated
+func (p *Parser) parseLogicalExpression(left ast.Expression) ast.Expression {
+	expression := &ast.LogicalExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Left:     left,
+	}
+
+	precedence := p.curPrecedence()
+	p.nextToken()
+	expression.Right = p.parseExpression(precedence)
+
+	return expression
+}
+
+// parse `<target> = <expression>`, where the target is a variable or an index expression.
+// Assignments group to the right: a = b = 1 is a = (b = 1)
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   left,
+	}
+
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case *ast.BadExpression:
+		// already reported
+	default:
+		d := diagnostic.New(diagnostic.InvalidTarget, p.curToken, "cannot assign to %s", left.String())
+		p.addError(d)
+	}
+
+	p.nextToken()
+	// one less than ASSIGN, so the next assignment goes to the right side
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 // parse e.g. input : (5 + 5) * 2;
 // for <(> <expression> )
 func (p *Parser) parseGroupedExpression() ast.Expression {
@@ -461,7 +931,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 
 	// check next token whether is ")"
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return &ast.BadExpression{Token: p.curToken}
 	}
 
 	return exp
@@ -481,7 +951,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 	// check next token whether is "("
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return &ast.BadExpression{Token: expression.Token}
 	}
 	// skip "("
 	p.nextToken()
@@ -490,12 +960,12 @@ func (p *Parser) parseIfExpression() ast.Expression {
 
 	// check next token whether is ")"
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return &ast.BadExpression{Token: expression.Token}
 	}
 
 	// check next token whether is "{"
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return &ast.BadExpression{Token: expression.Token}
 	}
 
 	// parse BlockStatement to Consequence
@@ -506,9 +976,24 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		// skip "else"
 		p.nextToken()
 
+		// else if: the alternative is a block holding the next if expression
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			tok := p.curToken
+			nested := p.parseIfExpression()
+			if _, ok := nested.(*ast.BadExpression); ok {
+				return nested
+			}
+			expression.Alternative = &ast.BlockStatement{
+				Token:      tok,
+				Statements: []ast.Statement{&ast.ExpressionStatement{Token: tok, Expression: nested}},
+			}
+			return expression
+		}
+
 		// check next token whether is "{"
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return &ast.BadExpression{Token: expression.Token}
 		}
 
 		// parse BlockStatement to Alternative
@@ -518,12 +1003,315 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+// parse `try { <statement>* } catch ( <identifier> ) { <statement>* } finally { <statement>* }`
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return &ast.BadExpression{Token: expression.Token}
+	}
+	expression.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return &ast.BadExpression{Token: expression.Token}
+		}
+		expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return &ast.BadExpression{Token: expression.Token}
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && !p.peekTokenIs(token.FINALLY) {
+		d := diagnostic.New(diagnostic.UnexpectedToken, p.peekToken,
+			"expected next token to be catch or finally, got %s instead", p.peekToken.Type)
+		d.Expected = []token.TokenType{token.CATCH, token.FINALLY}
+		p.addError(d.WithHint("a try needs a catch or a finally block"))
+		return &ast.BadExpression{Token: expression.Token}
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return &ast.BadExpression{Token: expression.Token}
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	return expression
+}
+
+// parse `match ( <expression> ) { <arm>, ... }`,
+// the "," after an arm whose body is a block is optional
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return &ast.BadExpression{Token: expression.Token}
+	}
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return &ast.BadExpression{Token: expression.Token}
+	}
+	if !p.expectPeek(token.LBRACE) {
+		return &ast.BadExpression{Token: expression.Token}
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return &ast.BadExpression{Token: expression.Token}
+		}
+		expression.Arms = append(expression.Arms, arm)
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.curTokenIs(token.RBRACE) && !p.peekTokenIs(token.RBRACE) {
+			p.peekError(token.COMMA)
+			return &ast.BadExpression{Token: expression.Token}
+		}
+	}
+
+	// skip to "}"
+	p.nextToken()
+	expression.Rbrace = p.curToken
+
+	return expression
+}
+
+// parse `<pattern> [when <expression>] => <body>`, the body is a block or an expression.
+// A hash literal as body needs parentheses, `{` starts a block.
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{}
+
+	arm.Pattern = p.parsePattern()
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.WHEN) {
+		// skip "when"
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	p.nextToken()
+
+	if p.curTokenIs(token.LBRACE) {
+		arm.Body = p.parseBlockStatement()
+	} else {
+		arm.Body = &ast.ExpressionStatement{Token: p.curToken, Expression: p.parseExpression(LOWEST)}
+	}
+
+	return arm
+}
+
+// parse `<pattern> [| <pattern>]*`
+func (p *Parser) parsePattern() ast.Pattern {
+	pattern := p.parseSinglePattern()
+	if pattern == nil || !p.peekTokenIs(token.PIPE) {
+		return pattern
+	}
+
+	alternatives := &ast.AlternativePattern{Alternatives: []ast.Pattern{pattern}}
+	for p.peekTokenIs(token.PIPE) {
+		// skip "|"
+		p.nextToken()
+		p.nextToken()
+
+		pattern := p.parseSinglePattern()
+		if pattern == nil {
+			return nil
+		}
+		alternatives.Alternatives = append(alternatives.Alternatives, pattern)
+	}
+	return alternatives
+}
+
+// parse `_`, a name, a literal, `[<pattern>, ...]` or `{<literal>: <pattern>, ...}`
+func (p *Parser) parseSinglePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return &ast.BindingPattern{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+		return &ast.LiteralPattern{Value: p.prefixParseFns[p.curToken.Type]()}
+	case token.MINUS:
+		// only numbers can be negated
+		if !p.peekTokenIs(token.INT) && !p.peekTokenIs(token.FLOAT) {
+			p.invalidPatternError(p.peekToken)
+			return nil
+		}
+		exp := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+		p.nextToken()
+		exp.Right = p.prefixParseFns[p.curToken.Type]()
+		return &ast.LiteralPattern{Value: exp}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	default:
+		p.invalidPatternError(p.curToken)
+		return nil
+	}
+}
+
+// parse `[<pattern>, ...]`, the last element can be `...<identifier>`
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		el := p.parsePatternElement()
+		if el == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, el)
+
+		if _, ok := el.(*ast.RestPattern); ok && !p.peekTokenIs(token.RBRACKET) {
+			p.peekError(token.RBRACKET)
+			return nil
+		}
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	// skip to "]"
+	p.nextToken()
+	pattern.Rbracket = p.curToken
+
+	return pattern
+}
+
+// parse `{<key>: <pattern>, ...}`, the keys are strings, integers, booleans or names.
+// A name alone, `{name}`, is short for `{"name": name}`. The patterns can have defaults.
+func (p *Parser) parseHashPattern() ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		var key ast.Expression
+		switch p.curToken.Type {
+		case token.IDENT:
+			key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		case token.STRING, token.INT, token.TRUE, token.FALSE:
+			key = p.prefixParseFns[p.curToken.Type]()
+		default:
+			p.invalidPatternError(p.curToken)
+			return nil
+		}
+
+		var value ast.Pattern
+		if p.curTokenIs(token.IDENT) && !p.peekTokenIs(token.COLON) {
+			value = &ast.BindingPattern{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+		} else {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+			p.nextToken()
+
+			if value = p.parsePattern(); value == nil {
+				return nil
+			}
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, p.parseDefault(value))
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	// skip to "}"
+	p.nextToken()
+	pattern.Rbrace = p.curToken
+
+	return pattern
+}
+
+// parse an element of an array pattern or a parameter:
+// `...<identifier>` or `<pattern> [= <expression>]`
+func (p *Parser) parsePatternElement() ast.Pattern {
+	if p.curTokenIs(token.ELLIPSIS) {
+		rest := &ast.RestPattern{Token: p.curToken}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		rest.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		return rest
+	}
+
+	pattern := p.parsePattern()
+	if pattern == nil {
+		return nil
+	}
+	return p.parseDefault(pattern)
+}
+
+// parse the `= <expression>` following pattern, if any
+func (p *Parser) parseDefault(pattern ast.Pattern) ast.Pattern {
+	if !p.peekTokenIs(token.ASSIGN) {
+		return pattern
+	}
+	// skip "="
+	p.nextToken()
+	p.nextToken()
+	return &ast.DefaultPattern{Pattern: pattern, Default: p.parseExpression(LOWEST)}
+}
+
+// literals and alternatives may not match, only the arms of a match can use them
+func (p *Parser) checkDestructuring(pattern ast.Pattern) bool {
+	switch pattern := pattern.(type) {
+	case *ast.LiteralPattern, *ast.AlternativePattern:
+		d := diagnostic.NewSpan(diagnostic.InvalidPattern, pattern.Pos(), pattern.End(),
+			"pattern %s may not match, it is only allowed in match", pattern.String())
+		p.addError(d)
+		return false
+	case *ast.DefaultPattern:
+		return p.checkDestructuring(pattern.Pattern)
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			if !p.checkDestructuring(el) {
+				return false
+			}
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			if !p.checkDestructuring(value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (p *Parser) invalidPatternError(tok token.Token) {
+	d := diagnostic.New(diagnostic.InvalidPattern, tok, "unexpected %s in pattern", tok.Type)
+	d.WithHint("patterns are _, names, literals, [...] and {...}, combined with |")
+	p.addError(d)
+}
+
 // parse the call function
 // "(function)<expression>( (Arguments[])<expression>* )"
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	// parse the arguments
-	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Arguments = p.parseCallArguments()
+	// the list parser stops on the closing ")"
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -543,6 +1331,21 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+// the lexer hands out a broken string or a block comment left open as ILLEGAL
+func (p *Parser) parseIllegal() ast.Expression {
+	literal := p.curToken.Literal
+	switch {
+	case strings.HasPrefix(literal, `"`) || strings.HasPrefix(literal, "`"):
+		_, err := lexer.Unquote(literal)
+		p.addError(diagnostic.New(diagnostic.InvalidString, p.curToken, "%s", err))
+	case strings.HasPrefix(literal, "/*"):
+		p.addError(diagnostic.New(diagnostic.InvalidString, p.curToken, "comment not terminated"))
+	default:
+		p.noPrefixParseFnError(p.curToken.Type)
+	}
+	return &ast.BadExpression{Token: p.curToken}
+}
+
 // parse Array literal
 // <[> <literal> ]
 // for [
@@ -550,6 +1353,8 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	// the list parser stops on the closing "]"
+	array.Rbracket = p.curToken
 
 	return array
 }
@@ -571,7 +1376,7 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	p.nextToken()
 	// means there will be at least one argument
 	// parse expression with precedence LOWEST, then append it to the args[]
-	list = append(list, p.parseExpression(LOWEST))
+	list = append(list, p.parseListElement())
 
 	// check whether next token is ","
 	for p.peekTokenIs(token.COMMA) {
@@ -579,7 +1384,7 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 		p.nextToken()
 		p.nextToken()
 
-		list = append(list, p.parseExpression(LOWEST))
+		list = append(list, p.parseListElement())
 	}
 
 	// check the next token whether is end token
@@ -590,6 +1395,62 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	return list
 }
 
+// an element of an array literal or an argument: an expression, or `...<expression>`
+func (p *Parser) parseListElement() ast.Expression {
+	if !p.curTokenIs(token.ELLIPSIS) {
+		return p.parseExpression(LOWEST)
+	}
+	spread := &ast.SpreadExpression{Token: p.curToken}
+	p.nextToken()
+	spread.Value = p.parseExpression(LOWEST)
+	return spread
+}
+
+// parse the arguments of a call up to the ")": elements of a list, then the arguments
+// named after their parameter, `<identifier>: <expression>`
+func (p *Parser) parseCallArguments() []ast.Expression {
+	args := []ast.Expression{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return args
+	}
+
+	named := false
+	for {
+		// skip "(" or ","
+		p.nextToken()
+
+		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.COLON) {
+			arg := &ast.NamedArgument{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+			// skip ":"
+			p.nextToken()
+			p.nextToken()
+			arg.Value = p.parseExpression(LOWEST)
+			args = append(args, arg)
+			named = true
+		} else {
+			if named {
+				d := diagnostic.New(diagnostic.InvalidArgument, p.curToken, "positional argument after named argument")
+				d.WithHint("pass the positional arguments first")
+				p.addError(d)
+				return nil
+			}
+			args = append(args, p.parseListElement())
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return args
+}
+
 // parse index expression
 // <[> <integer literal> ]
 // for [
@@ -603,8 +1464,9 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 
 	// check next token whether is "]"
 	if !p.expectPeek(token.RBRACKET) {
-		return nil
+		return &ast.BadExpression{Token: exp.Token}
 	}
+	exp.Rbracket = p.curToken
 
 	return exp
 }
@@ -625,7 +1487,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		key := p.parseExpression(LOWEST)
 		// if the next token isn't ":"
 		if !p.expectPeek(token.COLON) {
-			return nil
+			return &ast.BadExpression{Token: hash.Token}
 		}
 		// skip ":"
 		p.nextToken()
@@ -636,20 +1498,25 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		hash.Pairs[key] = value
 		// if next token isn't "}" and  next token also isn't ","
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
-			return nil
+			return &ast.BadExpression{Token: hash.Token}
 		}
 	}
 	// the parser end at the token "}"
 	if !p.expectPeek(token.RBRACE) {
-		return nil
+		return &ast.BadExpression{Token: hash.Token}
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
 
 // Error handle for no prefix error
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	d := diagnostic.New(diagnostic.NoPrefixParseFn, p.curToken,
+		"no prefix parse function for %s found", t)
+	if closing[t] || t == token.SEMICOLON {
+		d.WithHint("an expression was expected before %s", t)
+	}
+	p.addError(d)
 }
 
 // check the current cursor token whether matches the want token type
@@ -3,7 +3,10 @@ package parser
 import (
 	"fmt"
 	"lexer-parser/ast"
+	"lexer-parser/diagnostic"
 	"lexer-parser/lexer"
+	"lexer-parser/token"
+	"strings"
 	"testing"
 )
 
@@ -40,6 +43,35 @@ func TestLetStatements(t *testing.T) {
 	}
 }
 
+func TestConstStatements(t *testing.T) {
+	input := "const MAX_RETRIES = 3; let x = MAX_RETRIES"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.LetStatement. got=%T", program.Statements[0])
+	}
+	if !stmt.IsConst() || stmt.Name.Value != "MAX_RETRIES" {
+		t.Errorf("wrong const statement. got=%q", stmt.String())
+	}
+	testLiteralExpression(t, stmt.Value, 3)
+
+	if program.Statements[1].(*ast.LetStatement).IsConst() {
+		t.Errorf("let statement is const")
+	}
+	if program.String() != "const MAX_RETRIES = 3;let x = MAX_RETRIES;" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+}
+
 func TestReturnStatements(t *testing.T) {
 	tests := []struct {
 		input         string
@@ -136,6 +168,51 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiteralExpression(t *testing.T) {
+	input := `99999999999999999999;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+	}
+	if literal.Big == nil || literal.Big.String() != "99999999999999999999" {
+		t.Errorf("literal.Big not 99999999999999999999. got=%v", literal.Big)
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9;", 1e-9},
+		{".5;", 0.5},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTexts := []struct {
 		input    string
@@ -259,6 +336,26 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b * c + d / e - f",
 			"(((a + (b * c)) + (d / e)) - f)",
 		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"!a || b < c + 1",
+			"((!a) || (b < (c + 1)))",
+		},
 		{
 			"3 + 4; -5 * 5",
 			"(3 + 4)((-5) * 5)",
@@ -391,6 +488,150 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "(x = 5)"},
+		{"x = y = 1 + 2", "(x = (y = (1 + 2)))"},
+		{"x += 2 * 3", "(x += (2 * 3))"},
+		{"x -= 1; x *= 2; x /= 3", "(x -= 1)(x *= 2)(x /= 3)"},
+		{"a[i + 1] = b[0]", "((a[(i + 1)]) = (b[0]))"},
+		{`h["k"] += 1`, "((h[k]) += 1)"},
+		{"x = a || b", "(x = (a || b))"},
+		{"f(x = 1)", "f((x = 1))"},
+		{"let y = x = 2;", "let y = (x = 2);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x }", "while (x < 10) x"},
+		{"while (true) { break; continue }", "while true break;continue;"},
+		{"for (let i = 0; i < 10; let i = i + 1) { i }", "for (let i = 0; (i < 10); let i = (i + 1)) i"},
+		{"for (;;) { break }", "for (; ; ) break;"},
+		{"for (x in [1, 2]) { x };", "for (x in [1, 2]) x"},
+		{"for (x in xs) { for (y in ys) { break } }", "for (x in xs) for (y in ys) break;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement for %q. got=%d", tt.input, len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < 0) { -1 } else if (x == 0) { 0 } else { 1 }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	exp, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("expression is not ast.IfExpression. got=%T", program.Statements[0].(*ast.ExpressionStatement).Expression)
+	}
+	if exp.Alternative == nil || len(exp.Alternative.Statements) != 1 {
+		t.Fatalf("alternative does not hold the else if. got=%+v", exp.Alternative)
+	}
+	nested, ok := exp.Alternative.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("alternative is not ast.IfExpression. got=%T", exp.Alternative.Statements[0])
+	}
+	if !testInfixExpression(t, nested.Condition, "x", "==", 0) {
+		return
+	}
+	if nested.Alternative == nil {
+		t.Fatalf("the last else is missing")
+	}
+	if exp.End().Column != len(input)+1 {
+		t.Errorf("wrong end. got=%s", exp.End())
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { 1 => "one", _ => "other" }`, "match x {1 => one, _ => other}"},
+		{`match (x) { 1 | 2 | -3 => a, 2.5 | true => b, }`, "match x {1 | 2 | (-3) => a, 2.5 | true => b}"},
+		{`match (p) { [x, y] when x > y => x, [x, _] => { let z = x; z } _ => 0 }`,
+			"match p {[x, y] when (x > y) => x, [x, _] => let z = x;z, _ => 0}"},
+		{`match (h) { {"name": n, 1: [a, _]} => n }`, "match h {{name: n, 1: [a, _]} => n}"},
+		{`match (x) {}`, "match x {}"},
+		{`let y = match (x) { n => n + 1 } * 2`, "let y = (match x {n => (n + 1)} * 2);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestDestructuringParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let [a, b] = arr;", "let [a, b] = arr;"},
+		{"let [a, b, ...rest] = arr", "let [a, b, ...rest] = arr;"},
+		{"let [x = 0, [y, _]] = arr", "let [x = 0, [y, _]] = arr;"},
+		{"let {name, age: years} = person", "let {name: name, age: years} = person;"},
+		{`let {name = "anon", "tags": [first, ...others] = []} = person`, "let {name: name = anon, tags: [first, ...others] = []} = person;"},
+		{"const [a] = [1]", "const [a] = [1];"},
+		{"fn([head, ...tail]) { head }", "fn([head, ...tail])head"},
+		{"fn(a, b = a + 1, ...rest) { a }", "fn(a, b = (a + 1), ...rest)a"},
+		{"fn({x, y}) { x }", "fn({x: x, y: y})x"},
+		{"match (x) { [head, ...tail] => head }", "match x {[head, ...tail] => head}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 	l := lexer.New(input)
@@ -416,8 +657,8 @@ func TestFunctionLiteralParsing(t *testing.T) {
 		t.Fatalf("function literal parameters wrong. want 2, got=%d/n", len(function.Parameters))
 	}
 
-	testLiteralExpression(t, function.Parameters[0], "x")
-	testLiteralExpression(t, function.Parameters[1], "y")
+	testLiteralExpression(t, function.Parameters[0].(*ast.BindingPattern).Name, "x")
+	testLiteralExpression(t, function.Parameters[1].(*ast.BindingPattern).Name, "y")
 
 	if len(function.Body.Statements) != 1 {
 		t.Fatalf("function literal body statements wrong. want 1, got=%d/n", len(function.Body.Statements))
@@ -455,7 +696,7 @@ func TestFunctionParameterParsing(t *testing.T) {
 		}
 
 		for i, ident := range tt.expectedParams {
-			testLiteralExpression(t, function.Parameters[i], ident)
+			testLiteralExpression(t, function.Parameters[i].(*ast.BindingPattern).Name, ident)
 		}
 	}
 }
@@ -492,6 +733,190 @@ func TestCallExpressionParsing(t *testing.T) {
 
 }
 
+func TestTryParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`throw "boom";`, "throw boom;"},
+		{"throw x + 1", "throw (x + 1);"},
+		{"try { f() } catch (e) { g(e) }", "try f() catch (e) g(e)"},
+		{"try { f() } finally { done() }", "try f() finally done()"},
+		{"try { f() } catch (e) { 1 } finally { 2 }", "try f() catch (e) 1 finally 2"},
+		{"let x = try { f() } catch (e) { 0 };", "let x = try f() catch (e) 0;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d", 1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T", stmt.Expression)
+	}
+
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d", len(macro.Parameters))
+	}
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d", len(macro.Body.Statements))
+	}
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T", macro.Body.Statements[0])
+	}
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+
+	if program.String() != "macro(x, y)(x + y)" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// header
+
+let a = 1; // one
+let f = fn(x) {
+	// inside
+	x /* value */
+};
+match (a) {
+	// first
+	1 => "one",
+	_ => "other" // rest
+}
+let g = fn() {
+	// empty
+};
+let h = fn(x, // the x
+	y) {
+	[1, /* two */ 2, {"a": 3 /* three */}]
+};
+if (a) { f(x) } // then
+else { f(1, 2 // two
+) }
+// the end`
+
+	p := New(lexer.NewWithComments(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	// they are not part of the program
+	if program.String() != New(lexer.New(input)).ParseProgram().String() {
+		t.Fatalf("comments change the program. got=%q", program.String())
+	}
+
+	texts := func(comments []*ast.Comment) string {
+		literals := []string{}
+		for _, c := range comments {
+			literals = append(literals, c.Token.Literal)
+		}
+		return strings.Join(literals, " | ")
+	}
+
+	letA := program.Statements[0]
+	body := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body
+	match := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	letG := program.Statements[3]
+	empty := letG.(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body
+	h := program.Statements[4].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	array := h.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ArrayLiteral)
+	hash := array.Elements[2].(*ast.HashLiteral)
+	ifStmt := program.Statements[5]
+	ifExp := ifStmt.(*ast.ExpressionStatement).Expression.(*ast.IfExpression)
+	call := ifExp.Alternative.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+
+	tests := []struct {
+		node     ast.Node
+		leading  string
+		trailing string
+	}{
+		{letA, "// header", "// one"},
+		{body.Statements[0], "// inside", "/* value */"},
+		{match.Arms[0], "// first", ""},
+		{match.Arms[1], "", "// rest"},
+		{empty, "// empty", ""},
+		{h.Parameters[0], "", "// the x"},
+		{array.Elements[1], "/* two */", ""},
+		{hash.Pairs[hash.Keys()[0]], "", "/* three */"},
+		{ifExp.Consequence, "", "// then"},
+		{call.Arguments[1], "", "// two"},
+		{ifStmt, "", "// the end"},
+	}
+
+	for _, tt := range tests {
+		comments := program.Comments[tt.node]
+		if comments == nil {
+			t.Errorf("no comments for %s", tt.node)
+			continue
+		}
+		if got := texts(comments.Leading); got != tt.leading {
+			t.Errorf("wrong leading comments of %s. expected=%q, got=%q", tt.node, tt.leading, got)
+		}
+		if got := texts(comments.Trailing); got != tt.trailing {
+			t.Errorf("wrong trailing comments of %s. expected=%q, got=%q", tt.node, tt.trailing, got)
+		}
+	}
+	if len(program.Comments) != len(tests) {
+		t.Errorf("wrong number of nodes with comments. expected=%d, got=%d", len(tests), len(program.Comments))
+	}
+
+	if program := New(lexer.New(input)).ParseProgram(); program.Comments != nil {
+		t.Errorf("comments were not skipped")
+	}
+}
+
+func TestCallArgumentParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(...args)", "f(...args)"},
+		{"f(1, ...rest, 2)", "f(1, ...rest, 2)"},
+		{"f(...a + b)", "f(...(a + b))"},
+		{"f(b: 2, a: 1)", "f(b: 2, a: 1)"},
+		{"f(1, b: x * 2)", "f(1, b: (x * 2))"},
+		{"[0, ...xs]", "[0, ...xs]"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
 func TestStringLIteralExpression(t *testing.T) {
 	input := `"hello world";`
 
@@ -636,6 +1061,195 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 	}
 }
 
+// the spans of the nodes, from their first to their last token
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(x, y) {
+	x + y;
+};
+add(1, [2, 3][0]);
+{"a": -1}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	letStmt := program.Statements[0].(*ast.LetStatement)
+	function := letStmt.Value.(*ast.FunctionLiteral)
+	body := function.Body.Statements[0].(*ast.ExpressionStatement)
+	call := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	index := call.Arguments[1].(*ast.IndexExpression)
+	hash := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.HashLiteral)
+
+	tests := []struct {
+		node     ast.Node
+		expected string
+	}{
+		{letStmt, "1:1-3:2"},
+		{function, "1:11-3:2"},
+		{function.Body, "1:20-3:2"},
+		{body, "2:2-2:7"},
+		{call, "4:1-4:18"},
+		{index, "4:8-4:17"},
+		{index.Left, "4:8-4:14"},
+		{hash, "5:1-5:10"},
+		{program, "1:1-5:10"},
+	}
+
+	for _, tt := range tests {
+		actual := fmt.Sprintf("%s-%s", tt.node.Pos(), tt.node.End())
+		if actual != tt.expected {
+			t.Errorf("wrong span for %q. expected=%s, got=%s", tt.node.String(), tt.expected, actual)
+		}
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedCode     string
+		expectedPos      string
+		expectedExpected []token.TokenType
+		expectedActual   token.TokenType
+	}{
+		{"let x 5;", diagnostic.UnexpectedToken, "1:7", []token.TokenType{token.ASSIGN}, token.INT},
+		{"let x = 1;\nadd(x, 2;", diagnostic.UnexpectedToken, "2:9", []token.TokenType{token.RPAREN}, token.SEMICOLON},
+		{"let x = );", diagnostic.NoPrefixParseFn, "1:9", nil, token.RPAREN},
+		{"1e999", diagnostic.InvalidFloat, "1:1", nil, token.FLOAT},
+		{"break;", diagnostic.OutsideLoop, "1:1", nil, token.BREAK},
+		{"f() = 1", diagnostic.InvalidTarget, "1:5", nil, token.ASSIGN},
+		{"1 += 1", diagnostic.InvalidTarget, "1:3", nil, token.PLUS_ASSIGN},
+		{"match (x) { a + 1 => 2 }", diagnostic.UnexpectedToken, "1:15", []token.TokenType{token.ARROW}, token.PLUS},
+		{"match (x) { f() => 2 }", diagnostic.UnexpectedToken, "1:14", []token.TokenType{token.ARROW}, token.LPAREN},
+		{"match (x) { -a => 2 }", diagnostic.InvalidPattern, "1:14", nil, token.IDENT},
+		{"match (x) { {[k]: 1} => 2 }", diagnostic.InvalidPattern, "1:14", nil, token.LBRACKET},
+		{"let [a, 1] = x", diagnostic.InvalidPattern, "1:9", nil, ""},
+		{"let f = fn(a | b) { a }", diagnostic.InvalidPattern, "1:12", nil, ""},
+		{"let [...rest, a] = x", diagnostic.UnexpectedToken, "1:13", []token.TokenType{token.RBRACKET}, token.COMMA},
+		{"let f = fn(...rest, a) { a }", diagnostic.UnexpectedToken, "1:19", []token.TokenType{token.RPAREN}, token.COMMA},
+		{"let [...] = x", diagnostic.UnexpectedToken, "1:9", []token.TokenType{token.IDENT}, token.RBRACKET},
+		{"f(a: 1, 2)", diagnostic.InvalidArgument, "1:9", nil, token.INT},
+		{"try { 1 } 2", diagnostic.UnexpectedToken, "1:11", []token.TokenType{token.CATCH, token.FINALLY}, token.INT},
+		{"try { 1 } catch e { 2 }", diagnostic.UnexpectedToken, "1:17", []token.TokenType{token.LPAREN}, token.IDENT},
+		{"match (x) { 1 => 2 3 => 4 }", diagnostic.UnexpectedToken, "1:20", []token.TokenType{token.COMMA}, token.INT},
+		{"while (true) { fn() { continue; } }", diagnostic.OutsideLoop, "1:23", nil, token.CONTINUE},
+		{"let s = \"open;\nlet t = 1;", diagnostic.InvalidString, "1:9", nil, token.ILLEGAL},
+		{`puts("a\qb")`, diagnostic.InvalidString, "1:6", nil, token.ILLEGAL},
+		{"let x = 1; /* open\n/* nested */ still open", diagnostic.InvalidString, "1:12", nil, token.ILLEGAL},
+		{`let s = "héllo" + ;`, diagnostic.NoPrefixParseFn, "1:19", nil, token.SEMICOLON},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		if len(p.Errors()) == 0 {
+			t.Errorf("no diagnostics for %q", tt.input)
+			continue
+		}
+		d := p.Errors()[0]
+		if d.Severity != diagnostic.Error {
+			t.Errorf("wrong severity for %q. got=%s", tt.input, d.Severity)
+		}
+		if d.Code != tt.expectedCode {
+			t.Errorf("wrong code for %q. expected=%s, got=%s", tt.input, tt.expectedCode, d.Code)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong position for %q. expected=%s, got=%s", tt.input, tt.expectedPos, d.Pos)
+		}
+		if fmt.Sprint(d.Expected) != fmt.Sprint(tt.expectedExpected) {
+			t.Errorf("wrong expected tokens for %q. expected=%v, got=%v", tt.input, tt.expectedExpected, d.Expected)
+		}
+		if d.Actual != tt.expectedActual {
+			t.Errorf("wrong actual token for %q. expected=%s, got=%s", tt.input, tt.expectedActual, d.Actual)
+		}
+	}
+
+	p := New(lexer.New("1 /* open"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0].Message != "comment not terminated" {
+		t.Errorf("wrong diagnostics for an open comment. got=%v", p.Errors())
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `let x 5;
+let y = add(1, 2;
+let z = ;
+let ok = x + y;`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expectedErrors := []string{
+		"1:7: error[P001]: expected next token to be =, got INT instead",
+		"2:17: error[P001]: expected next token to be ), got ; instead",
+		"3:9: error[P002]: no prefix parse function for ; found",
+	}
+	if len(p.Errors()) != len(expectedErrors) {
+		t.Fatalf("wrong number of errors. expected=%d, got=%d (%v)", len(expectedErrors), len(p.Errors()), p.Errors())
+	}
+	for i, expected := range expectedErrors {
+		if p.Errors()[i].String() != expected {
+			t.Errorf("errors[%d] wrong. expected=%q, got=%q", i, expected, p.Errors()[i].String())
+		}
+	}
+
+	if len(program.Statements) != 4 {
+		t.Fatalf("program.Statements does not contain 4 statements. got=%d", len(program.Statements))
+	}
+	for i := 0; i < 3; i++ {
+		bad, ok := program.Statements[i].(*ast.BadStatement)
+		if !ok {
+			t.Fatalf("program.Statements[%d] is not *ast.BadStatement. got=%T", i, program.Statements[i])
+		}
+		if bad.Pos().Line != i+1 || bad.End().Line != i+1 {
+			t.Errorf("bad statement %d spans the wrong lines. got=%s-%s", i, bad.Pos(), bad.End())
+		}
+	}
+	if !testLetStatement(t, program.Statements[3], "ok") {
+		return
+	}
+	testInfixExpression(t, program.Statements[3].(*ast.LetStatement).Value, "x", "+", "y")
+}
+
+func TestErrorRecoveryInBlocks(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     int
+		expectedStatements int
+		expected           string
+	}{
+		{"let f = fn(x) { x + ; x }; let y = 2;", 1, 2, "let f = fn(x)<bad statement>x;let y = 2;"},
+		{"let f = fn(x) { x + }; f(1)", 1, 2, "let f = fn(x)<bad statement>;f(1)"},
+		{"if (x) { 1", 1, 1, "<bad statement>"},
+		{"add(1, 2 let a = 1;", 1, 2, "<bad statement>let a = 1;"},
+		{"[1, 2 + ]; {1: 2 3}; (1 + 2; 4", 3, 4, "<bad statement><bad statement><bad statement>4"},
+		{"let a = 5", 0, 1, "let a = 5;"},
+		{"return 5", 0, 1, "return 5;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if len(p.Errors()) != tt.expectedErrors {
+			t.Errorf("wrong number of errors for %q. expected=%d, got=%d (%v)",
+				tt.input, tt.expectedErrors, len(p.Errors()), p.Errors())
+		}
+		if len(program.Statements) != tt.expectedStatements {
+			t.Errorf("wrong number of statements for %q. expected=%d, got=%d",
+				tt.input, tt.expectedStatements, len(program.Statements))
+		}
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
 // testing equal for literal expression
 func testLiteralExpression(
 	t *testing.T,
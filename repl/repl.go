@@ -2,12 +2,18 @@ package repl
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"io"
+	"lexer-parser/ast"
+	"lexer-parser/compiler"
+	"lexer-parser/diagnostic"
 	"lexer-parser/evaluator"
 	"lexer-parser/lexer"
 	"lexer-parser/object"
 	"lexer-parser/parser"
+	"lexer-parser/resolver"
+	"lexer-parser/vm"
 	"strings"
 )
 
@@ -19,83 +25,208 @@ let reduce = fn(arr, initial, f) { let iter = fn(arr, result) { if (len(arr) ==
 let sum = fn(arr) { reduce(arr, 0, fn(initial, el) { initial + el }); };
 	`
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// unless(<condition>, <consequence>, <alternative>), assert(<condition>) throwing the
+// condition when it does not hold, and log(<expression>) printing the expression and its value.
+const builtinMacros = `
+let unless = macro(condition, consequence, alternative) { quote(if (!(unquote(condition))) { unquote(consequence) } else { unquote(alternative) }) };
+let assert = macro(condition) { quote(if (!(unquote(condition))) { throw "assertion failed: " + unquote(source(condition)) }) };
+let log = macro(expression) { quote(fn(value) { puts(unquote(source(expression)), value); value }(unquote(expression))) };
+	`
 
-	l := lexer.New(builtinFns)
-	p := parser.New(l)
-	program := p.ParseProgram()
-	evaluator.Eval(program, env)
+// Engine selects what runs the programs
+type Engine string
+
+// The vm only runs the core of the language: bindings, functions, conditionals, arrays and hashes.
+// Loops, assignments, const, match, destructuring, default and named arguments, try and throw
+// and quote are the evaluator's, a program using them is refused by the vm before it runs.
+// Errors of the vm have no position and no stack either, and its stack of 2048 values
+// ends recursion that is not a tail call around a depth of 1000, where the evaluator
+// goes on to its call depth limit
+const (
+	EngineEval Engine = "eval" // the tree-walking evaluator
+	EngineVM   Engine = "vm"   // the bytecode compiler and virtual machine
+)
 
-	for {
-		fmt.Fprint(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
-			return
-		}
+// a session keeps the bindings of the programs it ran, e.g. the lines of the REPL
+type session interface {
+	run(ctx context.Context, program *ast.Program) object.Object
+	// the parts of program the engine cannot run
+	unsupported(program *ast.Program) []*diagnostic.Diagnostic
+}
 
-		line := scanner.Text()
-		l = lexer.New(line)
-		p = parser.New(l)
+type evalSession struct {
+	env    *object.Environment
+	limits evaluator.Limits
+}
 
-		program = p.ParseProgram()
+func (s *evalSession) run(ctx context.Context, program *ast.Program) object.Object {
+	return evaluator.EvalContext(ctx, program, s.env, s.limits)
+}
 
-		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
-			continue
-		}
+func (s *evalSession) unsupported(program *ast.Program) []*diagnostic.Diagnostic { return nil }
 
-		evaluator := evaluator.Eval(program, env)
-		if evaluator != nil {
-			io.WriteString(out, evaluator.Inspect())
-			io.WriteString(out, "\n")
+type vmSession struct {
+	symbolTable *compiler.SymbolTable
+	constants   []object.Object
+	globals     []object.Object
+}
+
+func (s *vmSession) run(ctx context.Context, program *ast.Program) object.Object {
+	comp := compiler.NewWithState(s.symbolTable, s.constants)
+	if err := comp.Compile(program); err != nil {
+		return &object.Error{Message: err.Error()}
+	}
+
+	bytecode := comp.Bytecode()
+	s.constants = bytecode.Constants
+
+	machine := vm.NewWithGlobalsStore(bytecode, s.globals)
+	if err := machine.RunContext(ctx); err != nil {
+		if errObj, ok := err.(*object.Error); ok {
+			return errObj
 		}
+		return &object.Error{Message: err.Error()}
 	}
+	return machine.LastPoppedStackElem()
+}
+
+func (s *vmSession) unsupported(program *ast.Program) []*diagnostic.Diagnostic {
+	return compiler.Unsupported(program)
 }
 
-func StartHandle(raw string) (string, bool) {
+// a new session for engine, with the helpers of builtinFns defined.
+// The limits only apply to the evaluator, the vm just stops when the context is done.
+func newSession(engine Engine, limits evaluator.Limits) session {
+	var s session
+	switch engine {
+	case EngineVM:
+		s = &vmSession{
+			symbolTable: compiler.NewGlobalSymbolTable(),
+			constants:   []object.Object{},
+			globals:     vm.NewGlobalsStore(),
+		}
+	default:
+		s = &evalSession{env: object.NewEnvironment(), limits: limits}
+	}
+
 	l := lexer.New(builtinFns)
 	p := parser.New(l)
-	program := p.ParseProgram()
+	s.run(context.Background(), p.ParseProgram())
+	return s
+}
+
+// the macros of a session, the ones of builtinMacros to begin with
+func newMacroEnv() *object.Environment {
 	env := object.NewEnvironment()
-	evaluator.Eval(program, env)
+	evaluator.DefineMacros(parser.New(lexer.New(builtinMacros)).ParseProgram(), env)
+	return env
+}
 
-	raw = strings.ReplaceAll(raw, "\n", "")
-	sr := strings.NewReader(raw)
-	scanner := bufio.NewScanner(sr)
-	buf := new(strings.Builder)
-	buf.Grow(len(raw))
+// define the macros of program in env and expand their calls
+func expandMacros(ctx context.Context, program *ast.Program, env *object.Environment, limits evaluator.Limits) (*ast.Program, *object.Error) {
+	evaluator.DefineMacros(program, env)
+	expanded, err := evaluator.ExpandMacrosContext(ctx, program, env, limits)
+	if err != nil {
+		return nil, err.(*object.Error)
+	}
+	return expanded.(*ast.Program), nil
+}
 
-	check := true
+func Start(in io.Reader, out io.Writer, engine Engine) {
+	scanner := bufio.NewScanner(in)
+	s := newSession(engine, evaluator.Limits{})
+	r := resolver.New()
+	macros := newMacroEnv()
 
 	for {
+		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
-			return buf.String(), check
+			return
 		}
+
 		line := scanner.Text()
-		l = lexer.New(line)
-		p = parser.New(l)
+		l := lexer.New(line)
+		p := parser.New(l)
 
-		program = p.ParseProgram()
+		program := p.ParseProgram()
 
 		if len(p.Errors()) != 0 {
-			printParserErrors(buf, p.Errors())
-			check = false
+			printDiagnostics(out, line, p.Errors())
+			continue
+		}
+		program, err := expandMacros(context.Background(), program, macros, evaluator.Limits{})
+		if err != nil {
+			io.WriteString(out, err.Inspect())
+			io.WriteString(out, "\n")
+			continue
+		}
+		if errors := r.Resolve(program); len(errors) != 0 {
+			printDiagnostics(out, line, errors)
+			continue
+		}
+		if errors := s.unsupported(program); len(errors) != 0 {
+			printDiagnostics(out, line, errors)
 			continue
 		}
 
-		evaluator := evaluator.Eval(program, env)
-		if evaluator != nil {
-			io.WriteString(buf, evaluator.Inspect())
-			io.WriteString(buf, "\n")
+		evaluated := s.run(context.Background(), program)
+		if evaluated != nil {
+			io.WriteString(out, evaluated.Inspect())
+			io.WriteString(out, "\n")
 		}
 	}
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+// Result is the outcome of running a whole program with StartHandle
+type Result struct {
+	Output      string                   `json:"output"`
+	Diagnostics []*diagnostic.Diagnostic `json:"diagnostics,omitempty"`
+	Error       *object.Error            `json:"error,omitempty"` // the runtime error stopping the program
+}
+
+// StartHandle runs raw as one program, it reports false when the program has syntax errors,
+// the resolver finds a misuse of a constant or the engine cannot run the program. The macros are expanded before the resolver runs.
+// The source is parsed as a whole so diagnostics point at the right line.
+// The program is stopped with an error once ctx is done or it goes over limits.
+func StartHandle(ctx context.Context, raw string, engine Engine, limits evaluator.Limits) (Result, bool) {
+	s := newSession(engine, limits)
+
+	l := lexer.New(raw)
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+
+	diagnostics := p.Errors()
+	if len(diagnostics) == 0 {
+		expanded, err := expandMacros(ctx, program, newMacroEnv(), limits)
+		if err != nil {
+			return Result{Output: err.Inspect() + "\n", Error: err}, true
+		}
+		program = expanded
+		diagnostics = resolver.New().Resolve(program)
+	}
+	if len(diagnostics) == 0 {
+		diagnostics = s.unsupported(program)
+	}
+	if len(diagnostics) != 0 {
+		buf := new(strings.Builder)
+		printDiagnostics(buf, raw, diagnostics)
+		return Result{Output: buf.String(), Diagnostics: diagnostics}, false
+	}
+
+	result := Result{}
+	evaluated := s.run(ctx, program)
+	if evaluated != nil {
+		result.Output = evaluated.Inspect() + "\n"
+	}
+	if err, ok := evaluated.(*object.Error); ok {
+		result.Error = err
 	}
+	return result, true
+}
+
+// render every diagnostic below the offending source line
+func printDiagnostics(out io.Writer, source string, errors []*diagnostic.Diagnostic) {
+	io.WriteString(out, diagnostic.RenderAll(source, errors))
 }
@@ -0,0 +1,321 @@
+// Package resolver checks the bindings of a program before it runs, so misuses of constants
+// and of the names of patterns are reported with the source they come from.
+package resolver
+
+import (
+	"lexer-parser/ast"
+	"lexer-parser/diagnostic"
+	"lexer-parser/token"
+	"sort"
+	"strings"
+)
+
+// what the resolver knows about a name
+type binding struct {
+	constant bool
+	pos      token.Position // where the name was declared
+}
+
+// the names declared by a program or a function body,
+// the blocks of if and loops share the scope around them like in the evaluator
+type scope struct {
+	names map[string]binding
+	outer *scope
+}
+
+func newScope(outer *scope) *scope {
+	return &scope{names: make(map[string]binding), outer: outer}
+}
+
+func (s *scope) lookup(name string) (binding, bool) {
+	b, ok := s.names[name]
+	if !ok && s.outer != nil {
+		return s.outer.lookup(name)
+	}
+	return b, ok
+}
+
+// A Resolver remembers the global names of the programs it resolved,
+// so the lines of a REPL are checked against the ones before.
+//
+// Names are only known once declared: a function assigning a constant
+// declared after it is left to the evaluator. Constants only protect the
+// binding, the elements of a const array or hash can still be assigned.
+type Resolver struct {
+	scope  *scope
+	errors []*diagnostic.Diagnostic
+}
+
+func New() *Resolver {
+	return &Resolver{scope: newScope(nil)}
+}
+
+// Resolve reports the misuses of constants and patterns in program, in source order.
+// The names of a program with diagnostics are forgotten, it won't run.
+func (r *Resolver) Resolve(program *ast.Program) []*diagnostic.Diagnostic {
+	globals := make(map[string]binding, len(r.scope.names))
+	for name, b := range r.scope.names {
+		globals[name] = b
+	}
+	r.errors = nil
+
+	for _, stmt := range program.Statements {
+		r.resolve(stmt)
+	}
+
+	if len(r.errors) != 0 {
+		r.scope.names = globals
+	}
+	return r.errors
+}
+
+func (r *Resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			r.resolve(stmt)
+		}
+	case *ast.LetStatement:
+		r.resolve(node.Value)
+		if node.Pattern != nil {
+			r.resolvePattern(node.Pattern)
+			r.checkNames(node.Pattern)
+			r.declarePattern(node.Pattern, node.IsConst())
+		} else {
+			r.declare(node.Name, node.IsConst())
+		}
+	case *ast.ReturnStatement:
+		r.resolve(node.ReturnValue)
+	case *ast.ThrowStatement:
+		r.resolve(node.Value)
+	case *ast.ExpressionStatement:
+		r.resolve(node.Expression)
+	case *ast.WhileStatement:
+		r.resolve(node.Condition)
+		r.resolve(node.Body)
+	case *ast.ForStatement:
+		r.resolve(node.Init)
+		r.resolve(node.Condition)
+		r.resolve(node.Body)
+		r.resolve(node.Post)
+	case *ast.ForInStatement:
+		r.resolve(node.Iterable)
+		r.declare(node.Variable, false)
+		r.resolve(node.Body)
+	case *ast.PrefixExpression:
+		r.resolve(node.Right)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.LogicalExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.AssignExpression:
+		r.resolveAssign(node)
+	case *ast.IfExpression:
+		r.resolve(node.Condition)
+		r.resolve(node.Consequence)
+		if node.Alternative != nil {
+			r.resolve(node.Alternative)
+		}
+	case *ast.MatchExpression:
+		r.resolve(node.Subject)
+		// every arm has its own scope with the names of its pattern
+		for _, arm := range node.Arms {
+			r.scope = newScope(r.scope)
+			r.resolvePattern(arm.Pattern)
+			r.checkNames(arm.Pattern)
+			r.declarePattern(arm.Pattern, false)
+			r.resolve(arm.Guard)
+			r.resolve(arm.Body)
+			r.scope = r.scope.outer
+		}
+	case *ast.TryExpression:
+		r.resolve(node.Block)
+		// the caught error is only known in the catch block
+		if node.Catch != nil {
+			r.scope = newScope(r.scope)
+			r.declare(node.Param, false)
+			r.resolve(node.Catch)
+			r.scope = r.scope.outer
+		}
+		if node.Finally != nil {
+			r.resolve(node.Finally)
+		}
+	case *ast.FunctionLiteral:
+		r.scope = newScope(r.scope)
+		r.checkNames(node.Parameters...)
+		for _, param := range node.Parameters {
+			r.resolvePattern(param)
+			r.declarePattern(param, false)
+		}
+		r.resolve(node.Body)
+		r.scope = r.scope.outer
+	case *ast.MacroLiteral:
+		r.scope = newScope(r.scope)
+		for _, param := range node.Parameters {
+			r.declare(param, false)
+		}
+		r.resolve(node.Body)
+		r.scope = r.scope.outer
+	case *ast.CallExpression:
+		r.resolve(node.Function)
+		for _, arg := range node.Arguments {
+			r.resolve(arg)
+		}
+	case *ast.SpreadExpression:
+		r.resolve(node.Value)
+	case *ast.NamedArgument:
+		r.resolve(node.Value)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			r.resolve(el)
+		}
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+	case *ast.HashLiteral:
+		for _, key := range node.Keys() {
+			r.resolve(key)
+			r.resolve(node.Pairs[key])
+		}
+	}
+}
+
+func (r *Resolver) resolveAssign(node *ast.AssignExpression) {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		if b, ok := r.scope.lookup(target.Value); ok && b.constant {
+			d := diagnostic.NewSpan(diagnostic.AssignToConstant, node.Pos(), node.End(),
+				"cannot assign to constant %s", target.Value)
+			r.errors = append(r.errors, d.WithHint("%s is declared with const at %s", target.Value, b.pos))
+		}
+	default:
+		r.resolve(target)
+	}
+	r.resolve(node.Value)
+}
+
+// bind name in the current scope, a constant of that scope cannot be declared again
+func (r *Resolver) declare(name *ast.Identifier, constant bool) {
+	if name == nil {
+		return
+	}
+	if b, ok := r.scope.names[name.Value]; ok && b.constant {
+		d := diagnostic.NewSpan(diagnostic.RedeclaredConstant, name.Pos(), name.End(),
+			"cannot redeclare constant %s", name.Value)
+		r.errors = append(r.errors, d.WithHint("%s is declared with const at %s", name.Value, b.pos))
+		return
+	}
+	r.scope.names[name.Value] = binding{constant: constant, pos: name.Pos()}
+}
+
+// declare the names bound by pattern
+func (r *Resolver) declarePattern(pattern ast.Pattern, constant bool) {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		r.declare(pattern.Name, constant)
+	case *ast.RestPattern:
+		r.declare(pattern.Name, constant)
+	case *ast.DefaultPattern:
+		r.declarePattern(pattern.Pattern, constant)
+	case *ast.AlternativePattern:
+		for _, alt := range pattern.Alternatives {
+			r.declarePattern(alt, constant)
+		}
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			r.declarePattern(el, constant)
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			r.declarePattern(value, constant)
+		}
+	}
+}
+
+// the patterns, e.g. the parameters of a function, bind every name once
+func (r *Resolver) checkNames(patterns ...ast.Pattern) {
+	bound := map[string]bool{}
+	for _, pattern := range patterns {
+		for _, name := range r.names(pattern) {
+			if bound[name.Value] {
+				d := diagnostic.NewSpan(diagnostic.DuplicateBinding, name.Pos(), name.End(),
+					"%s is bound more than once", name.Value)
+				r.errors = append(r.errors, d)
+			}
+			bound[name.Value] = true
+		}
+	}
+}
+
+// the names bound by pattern, in source order. The alternatives of a pattern
+// have to bind the same names, whichever matches
+func (r *Resolver) names(pattern ast.Pattern) []*ast.Identifier {
+	switch pattern := pattern.(type) {
+	case *ast.BindingPattern:
+		return []*ast.Identifier{pattern.Name}
+	case *ast.RestPattern:
+		return []*ast.Identifier{pattern.Name}
+	case *ast.DefaultPattern:
+		return r.names(pattern.Pattern)
+	case *ast.AlternativePattern:
+		first := r.names(pattern.Alternatives[0])
+		for _, alt := range pattern.Alternatives[1:] {
+			if names := r.names(alt); nameList(names) != nameList(first) {
+				d := diagnostic.NewSpan(diagnostic.AlternativeNames, alt.Pos(), alt.End(),
+					"the alternatives of a pattern must bind the same names")
+				r.errors = append(r.errors, d.WithHint("%s binds %s, %s binds %s",
+					pattern.Alternatives[0], nameList(first), alt, nameList(names)))
+			}
+		}
+		return first
+	case *ast.ArrayPattern:
+		var names []*ast.Identifier
+		for _, el := range pattern.Elements {
+			names = append(names, r.names(el)...)
+		}
+		return names
+	case *ast.HashPattern:
+		var names []*ast.Identifier
+		for _, value := range pattern.Values {
+			names = append(names, r.names(value)...)
+		}
+		return names
+	}
+	return nil
+}
+
+// the names in alphabetical order, for messages and comparing them
+func nameList(names []*ast.Identifier) string {
+	if len(names) == 0 {
+		return "no name"
+	}
+	values := make([]string, len(names))
+	for i, name := range names {
+		values[i] = name.Value
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+// resolve the defaults of pattern, before its names are declared
+func (r *Resolver) resolvePattern(pattern ast.Pattern) {
+	switch pattern := pattern.(type) {
+	case *ast.DefaultPattern:
+		r.resolvePattern(pattern.Pattern)
+		r.resolve(pattern.Default)
+	case *ast.AlternativePattern:
+		for _, alt := range pattern.Alternatives {
+			r.resolvePattern(alt)
+		}
+	case *ast.ArrayPattern:
+		for _, el := range pattern.Elements {
+			r.resolvePattern(el)
+		}
+	case *ast.HashPattern:
+		for _, value := range pattern.Values {
+			r.resolvePattern(value)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package resolver
+
+import (
+	"lexer-parser/ast"
+	"lexer-parser/lexer"
+	"lexer-parser/parser"
+	"testing"
+)
+
+func TestConstants(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"const x = 1; x + 1", nil},
+		{"const x = 1; x = 2", []string{"1:14: error[R001]: cannot assign to constant x"}},
+		{"const x = 1; x += 2", []string{"1:14: error[R001]: cannot assign to constant x"}},
+		{"const x = 1; let x = 2", []string{"1:18: error[R002]: cannot redeclare constant x"}},
+		{"const x = 1; const x = 2", []string{"1:20: error[R002]: cannot redeclare constant x"}},
+		{"const x = 1; for (x in [1]) {}", []string{"1:19: error[R002]: cannot redeclare constant x"}},
+		{"let x = 1; const x = 2; x = 3", []string{"1:25: error[R001]: cannot assign to constant x"}},
+		{"let x = 1; x = 2", nil},
+		// functions see the constants around them
+		{"const x = 1; let f = fn() { x = 2 }", []string{"1:29: error[R001]: cannot assign to constant x"}},
+		{"const x = 1; let f = fn() { if (true) { [x = 2] } }", []string{"1:42: error[R001]: cannot assign to constant x"}},
+		// unless they shadow them
+		{"const x = 1; let f = fn(x) { x = 2 }", nil},
+		{"const x = 1; let f = fn() { let x = 2; x = 3 }", nil},
+		{"const x = 1; let f = fn() { const x = 2; }", nil},
+		{"const x = 1; match (5) { x => x = 2 }", nil},
+		{"const e = 1; try { 0 } catch (e) { e = 2 }", nil},
+		{"const x = 1; try { 0 } catch (e) { x = e } finally { x = 3 }", []string{
+			"1:36: error[R001]: cannot assign to constant x",
+			"1:54: error[R001]: cannot assign to constant x",
+		}},
+		{"const x = 1; match (5) { y when y > x => x = 2, _ => 0 }", []string{"1:42: error[R001]: cannot assign to constant x"}},
+		{"const [a, {b}] = x; b = 1", []string{"1:21: error[R001]: cannot assign to constant b"}},
+		{"const a = 1; let [b, a] = x", []string{"1:22: error[R002]: cannot redeclare constant a"}},
+		{"const a = 1; let f = fn([a, b = a]) { a = b }", nil},
+		{"const a = 1; let f = fn(b = fn() { a = 2 }) { b }", []string{"1:36: error[R001]: cannot assign to constant a"}},
+		// only the binding is constant
+		{"const a = [1]; a[0] = 2", nil},
+		{"const h = {}; h[\"k\"] = h", nil},
+		{"const a = 1; const b = 2;\na = 3;\nb = 4", []string{
+			"2:1: error[R001]: cannot assign to constant a",
+			"3:1: error[R001]: cannot assign to constant b",
+		}},
+		{`{"b": fn() { c = 1 }, "a": fn() { c = 2 }}`, nil},
+		{"const c = 0;\n{\"b\": fn() { c = 1 }, \"a\": fn() { c = 2 }}", []string{
+			"2:14: error[R001]: cannot assign to constant c",
+			"2:35: error[R001]: cannot assign to constant c",
+		}},
+	}
+
+	for _, tt := range tests {
+		errors := New().Resolve(parse(t, tt.input))
+
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. expected=%d, got=%d (%v)", tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+		for i, expected := range tt.expected {
+			if errors[i].String() != expected {
+				t.Errorf("diagnostics[%d] wrong for %q. expected=%q, got=%q", i, tt.input, expected, errors[i].String())
+			}
+		}
+	}
+}
+
+func TestPatternNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"match (2) { [a] | [a, _] => a, {a} | a => a, 1 | 2 => 0 }", nil},
+		{"match (2) { [a] | b => a }", []string{"1:19: error[R004]: the alternatives of a pattern must bind the same names"}},
+		{"match (2) { [a, b] | [b, a] | [a] => a }", []string{"1:31: error[R004]: the alternatives of a pattern must bind the same names"}},
+		{"match (2) { 1 | x => 0 }", []string{"1:17: error[R004]: the alternatives of a pattern must bind the same names"}},
+		{"let [a, a] = x", []string{"1:9: error[R003]: a is bound more than once"}},
+		{"let [a, {b: [c, a]}] = x", []string{"1:17: error[R003]: a is bound more than once"}},
+		{"let f = fn(a, a) { a }", []string{"1:15: error[R003]: a is bound more than once"}},
+		{"let f = fn(a, [b, ...a]) { a }", []string{"1:22: error[R003]: a is bound more than once"}},
+		{"match (x) { [a, a] => a }", []string{"1:17: error[R003]: a is bound more than once"}},
+		// different scopes can bind the same name
+		{"let f = fn(a) { fn(a) { a } }; let [b] = x; let [b] = x", nil},
+	}
+
+	for _, tt := range tests {
+		errors := New().Resolve(parse(t, tt.input))
+
+		if len(errors) != len(tt.expected) {
+			t.Errorf("wrong number of diagnostics for %q. expected=%d, got=%d (%v)", tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+		for i, expected := range tt.expected {
+			if errors[i].String() != expected {
+				t.Errorf("diagnostics[%d] wrong for %q. expected=%q, got=%q", i, tt.input, expected, errors[i].String())
+			}
+		}
+	}
+
+	errors := New().Resolve(parse(t, "match (2) { [a] | b => a }"))
+	if len(errors) != 1 || len(errors[0].Hints) != 1 || errors[0].Hints[0] != "[a] binds a, b binds b" {
+		t.Errorf("wrong hints. got=%v", errors)
+	}
+}
+
+func TestDiagnosticSpan(t *testing.T) {
+	errors := New().Resolve(parse(t, "const limit = 3;\nlimit = limit + 1;"))
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 diagnostic. got=%d", len(errors))
+	}
+
+	d := errors[0]
+	if d.Pos.String() != "2:1" || d.End.String() != "2:18" {
+		t.Errorf("wrong span. expected=2:1-2:18, got=%s-%s", d.Pos, d.End)
+	}
+	if len(d.Hints) != 1 || d.Hints[0] != "limit is declared with const at 1:7" {
+		t.Errorf("wrong hints. got=%q", d.Hints)
+	}
+}
+
+// the names of the programs before are known, unless they had diagnostics
+func TestResolverKeepsGlobals(t *testing.T) {
+	r := New()
+
+	if errors := r.Resolve(parse(t, "const x = 1;")); len(errors) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", errors)
+	}
+	if errors := r.Resolve(parse(t, "x = 2;")); len(errors) != 1 {
+		t.Errorf("expected x to be known as a constant. got=%v", errors)
+	}
+	if errors := r.Resolve(parse(t, "const y = 1; x = 2")); len(errors) != 1 {
+		t.Errorf("expected 1 diagnostic. got=%v", errors)
+	}
+	if errors := r.Resolve(parse(t, "let y = 2; y = 3")); len(errors) != 0 {
+		t.Errorf("y of the rejected program should be forgotten. got=%v", errors)
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has %d errors for %q: %v", len(p.Errors()), input, p.Errors())
+	}
+	return program
+}
@@ -0,0 +1,21 @@
+package vm
+
+import (
+	"lexer-parser/code"
+	"lexer-parser/object"
+)
+
+// the execution of one function call
+type Frame struct {
+	cl          *object.Closure
+	ip          int // the instruction pointer inside the function
+	basePointer int // the stack pointer before the call, locals are stored from here
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
@@ -0,0 +1,772 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"lexer-parser/code"
+	"lexer-parser/compiler"
+	"lexer-parser/object"
+	"math"
+	"math/big"
+)
+
+const StackSize = 2048
+const GlobalsSize = 65536
+const MaxFrames = 1024
+
+// the vm has its own literal objects, like the evaluator
+var (
+	True  = &object.Boolean{Value: true}
+	False = &object.Boolean{Value: false}
+	Null  = &object.Null{}
+)
+
+// VM executes the bytecode produced by the compiler with an operand stack
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp    int // Always points to the next value. Top of stack is stack[sp-1]
+
+	globals     []object.Object
+	globalNames []string
+
+	frames      []*Frame
+	framesIndex int
+
+	ctx   context.Context
+	steps int64 // the number of instructions executed
+}
+
+// how many instructions run between two looks at the context
+const cancelCheckInterval = 1024
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	return &VM{
+		constants: bytecode.Constants,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		globals:     make([]object.Object, GlobalsSize),
+		globalNames: bytecode.Globals,
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// keep the globals of previous runs, e.g. between REPL lines
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// a store for NewWithGlobalsStore
+func NewGlobalsStore() []object.Object {
+	return make([]object.Object, GlobalsSize)
+}
+
+func (vm *VM) globalName(index int) string {
+	if index < len(vm.globalNames) {
+		return vm.globalNames[index]
+	}
+	return fmt.Sprintf("global %d", index)
+}
+
+// the value of the last expression statement, the result of the program
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return newError("stack overflow: more than %d nested calls", MaxFrames)
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+// Run executes the instructions until the end of the program.
+// Runtime errors are returned as *object.Error, with the same messages as the evaluator.
+func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is Run, stopping with an "execution cancelled" error once ctx is done
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.ctx = ctx
+
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		vm.steps++
+		if vm.steps%cancelCheckInterval == 0 {
+			if err := vm.checkCancelled(); err != nil {
+				return err
+			}
+		}
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan,
+			code.OpGreaterEqual, code.OpLessEqual:
+			if err := vm.executeInfixOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpBang:
+			if err := vm.executeBangOperator(); err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			if err := vm.executeMinusOperator(); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			// the loop increments ip before reading the next instruction
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+			// a let statement has no value
+			vm.stack[vm.sp] = nil
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			// a let that was not run, e.g. in a branch not taken, left the global unset
+			global := vm.globals[globalIndex]
+			if global == nil {
+				return newError("identifier not found: %s", vm.globalName(int(globalIndex)))
+			}
+			if err := vm.push(global); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			if err := vm.push(vm.stack[frame.basePointer+int(localIndex)]); err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			definition := object.Builtins[builtinIndex]
+			if err := vm.push(definition.Builtin); err != nil {
+				return err
+			}
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure.Free[freeIndex]); err != nil {
+				return err
+			}
+
+		case code.OpCurrentClosure:
+			currentClosure := vm.currentFrame().cl
+			if err := vm.push(currentClosure); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(array); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+
+			// a return statement outside of any function ends the program
+			if vm.framesIndex == 1 {
+				return vm.halt(returnValue)
+			}
+
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(returnValue); err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			numFree := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.pushClosure(int(constIndex), int(numFree)); err != nil {
+				return err
+			}
+
+		default:
+			def, err := code.Lookup(byte(op))
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("opcode %s not implemented", def.Name)
+		}
+	}
+
+	return nil
+}
+
+func (vm *VM) checkCancelled() error {
+	if vm.ctx.Err() != nil {
+		return newError("execution cancelled")
+	}
+	return nil
+}
+
+// stop the program, leaving result as the last popped element
+func (vm *VM) halt(result object.Object) error {
+	vm.sp = 0
+	vm.stack[vm.sp] = result
+	vm.currentFrame().ip = len(vm.currentFrame().Instructions())
+	return nil
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return newError("stack overflow: more than %d values on the stack", StackSize)
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+// the operator of an infix instruction, as written in Monkey code
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreaterThan:  ">",
+	code.OpLessThan:     "<",
+	code.OpGreaterEqual: ">=",
+	code.OpLessEqual:    "<=",
+}
+
+// follows evalInfixExpression of the evaluator
+func (vm *VM) executeInfixOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+	operator := infixOperators[op]
+
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return vm.executeIntegerOperation(operator, left, right)
+	case isInteger(left) && isInteger(right):
+		return vm.executeBigIntOperation(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return vm.executeFloatOperation(operator, left, right)
+	case op == code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(object.Equals(left, right)))
+	case op == code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(!object.Equals(left, right)))
+	case left.Type() != right.Type():
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return vm.executeStringOperation(operator, left, right)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (vm *VM) executeIntegerOperation(operator string, left, right object.Object) error {
+	leftVal := left.(*object.Integer).Value
+	rightVal := right.(*object.Integer).Value
+
+	// results out of the int64 range are computed again with big integers
+	switch operator {
+	case "+":
+		sum := leftVal + rightVal
+		if (leftVal > 0 && rightVal > 0 && sum < 0) || (leftVal < 0 && rightVal < 0 && sum >= 0) {
+			return vm.executeBigIntOperation(operator, left, right)
+		}
+		return vm.push(&object.Integer{Value: sum})
+	case "-":
+		diff := leftVal - rightVal
+		if (rightVal < 0 && diff < leftVal) || (rightVal > 0 && diff > leftVal) {
+			return vm.executeBigIntOperation(operator, left, right)
+		}
+		return vm.push(&object.Integer{Value: diff})
+	case "*":
+		product := leftVal * rightVal
+		if leftVal != 0 && (product/leftVal != rightVal || (leftVal == -1 && rightVal == math.MinInt64)) {
+			return vm.executeBigIntOperation(operator, left, right)
+		}
+		return vm.push(&object.Integer{Value: product})
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return vm.executeBigIntOperation(operator, left, right)
+		}
+		return vm.push(&object.Integer{Value: leftVal / rightVal})
+	case "%":
+		if rightVal == 0 {
+			return newError("modulo by zero")
+		}
+		return vm.push(&object.Integer{Value: leftVal % rightVal})
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftVal < rightVal))
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftVal <= rightVal))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	case "==":
+		return vm.push(nativeBoolToBooleanObject(leftVal == rightVal))
+	case "!=":
+		return vm.push(nativeBoolToBooleanObject(leftVal != rightVal))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// integers too large for an int64, the result is demoted to an Integer when it fits again
+func (vm *VM) executeBigIntOperation(operator string, left, right object.Object) error {
+	leftVal := object.ToBig(left)
+	rightVal := object.ToBig(right)
+
+	switch operator {
+	case "+":
+		return vm.push(object.NewInteger(new(big.Int).Add(leftVal, rightVal)))
+	case "-":
+		return vm.push(object.NewInteger(new(big.Int).Sub(leftVal, rightVal)))
+	case "*":
+		return vm.push(object.NewInteger(new(big.Int).Mul(leftVal, rightVal)))
+	case "/":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return vm.push(object.NewInteger(new(big.Int).Quo(leftVal, rightVal)))
+	case "%":
+		if rightVal.Sign() == 0 {
+			return newError("modulo by zero")
+		}
+		return vm.push(object.NewInteger(new(big.Int).Rem(leftVal, rightVal)))
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0))
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0))
+	case "==":
+		return vm.push(nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0))
+	case "!=":
+		return vm.push(nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+}
+
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	default:
+		return obj.(*object.Float).Value
+	}
+}
+
+// an integer meeting a float is promoted to a float
+func (vm *VM) executeFloatOperation(operator string, left, right object.Object) error {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return vm.push(&object.Float{Value: leftVal + rightVal})
+	case "-":
+		return vm.push(&object.Float{Value: leftVal - rightVal})
+	case "*":
+		return vm.push(&object.Float{Value: leftVal * rightVal})
+	case "/":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		return vm.push(&object.Float{Value: leftVal / rightVal})
+	case "%":
+		if rightVal == 0 {
+			return newError("modulo by zero")
+		}
+		return vm.push(&object.Float{Value: math.Mod(leftVal, rightVal)})
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftVal < rightVal))
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftVal <= rightVal))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	case "==":
+		return vm.push(nativeBoolToBooleanObject(leftVal == rightVal))
+	case "!=":
+		return vm.push(nativeBoolToBooleanObject(leftVal != rightVal))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (vm *VM) executeStringOperation(operator string, left, right object.Object) error {
+	leftVal := left.(*object.String).Value
+	rightVal := right.(*object.String).Value
+
+	switch operator {
+	case "+":
+		return vm.push(&object.String{Value: leftVal + rightVal})
+	case "<":
+		return vm.push(nativeBoolToBooleanObject(leftVal < rightVal))
+	case ">":
+		return vm.push(nativeBoolToBooleanObject(leftVal > rightVal))
+	case "<=":
+		return vm.push(nativeBoolToBooleanObject(leftVal <= rightVal))
+	case ">=":
+		return vm.push(nativeBoolToBooleanObject(leftVal >= rightVal))
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	switch operand {
+	case True:
+		return vm.push(False)
+	case False:
+		return vm.push(True)
+	case Null:
+		return vm.push(True)
+	default:
+		return vm.push(False)
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	switch operand := operand.(type) {
+	case *object.Integer:
+		if operand.Value == math.MinInt64 {
+			return vm.push(object.NewInteger(new(big.Int).Neg(object.ToBig(operand))))
+		}
+		return vm.push(&object.Integer{Value: -operand.Value})
+	case *object.BigInt:
+		return vm.push(object.NewInteger(new(big.Int).Neg(operand.Value)))
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
+	default:
+		return newError("unknown operator: -%s", operand.Type())
+	}
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+
+	for i := startIndex; i < endIndex; i++ {
+		elements[i-startIndex] = vm.stack[i]
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	hashedPairs := make(map[object.HashKey]object.HashPair)
+
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+
+		pair := object.HashPair{Key: key, Value: value}
+
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+
+		hashedPairs[hashKey.HashKey()] = pair
+	}
+
+	return &object.Hash{Pairs: hashedPairs}, nil
+}
+
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return vm.executeHashIndex(left, index)
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
+func (vm *VM) executeArrayIndex(array, index object.Object) error {
+	arrayObject := array.(*object.Array)
+	i := index.(*object.Integer).Value
+	max := int64(len(arrayObject.Elements) - 1)
+
+	if i < 0 || i > max {
+		return vm.push(Null)
+	}
+
+	return vm.push(arrayObject.Elements[i])
+}
+
+func (vm *VM) executeHashIndex(hash, index object.Object) error {
+	hashObject := hash.(*object.Hash)
+
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+
+	pair, ok := hashObject.Pairs[key.HashKey()]
+	if !ok {
+		return vm.push(Null)
+	}
+
+	return vm.push(pair.Value)
+}
+
+// the callee sits below its numArgs arguments on the stack
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return newError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return newError("wrong number of arguments: want=%d, got=%d", cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := NewFrame(cl, vm.sp-numArgs)
+	if err := vm.pushFrame(frame); err != nil {
+		return err
+	}
+
+	// the arguments are the first locals, make room for the others
+	vm.sp = frame.basePointer + cl.Fn.NumLocals
+	if vm.sp >= StackSize {
+		return newError("stack overflow: more than %d values on the stack", StackSize)
+	}
+
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	result := builtin.Fn(args...)
+	vm.sp = vm.sp - numArgs - 1
+
+	// errors stop the program, like they do in the evaluator
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	if result != nil {
+		return vm.push(result)
+	}
+	return vm.push(Null)
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("not a function: %+v", constant)
+	}
+
+	free := make([]object.Object, numFree)
+	for i := 0; i < numFree; i++ {
+		free[i] = vm.stack[vm.sp-numFree+i]
+	}
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,254 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"lexer-parser/ast"
+	"lexer-parser/compiler"
+	"lexer-parser/lexer"
+	"lexer-parser/object"
+	"lexer-parser/parser"
+	"strings"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * (2 + 10)", 60},
+		{"-50 + 100 + -50", 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"!(if (false) { 5; })", true},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"let one = 1; let one = one + 1; one", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrayAndHashLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1 + 2, 3 * 4][1]", 12},
+		{"[1, 2, 3][99]", Null},
+		{`{"one": 1, "two": 2}["two"]`, 2},
+		{`{}["foo"]`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{
+			`
+			let globalSeed = 50;
+			let minusOne = fn() { let num = 1; globalSeed - num; };
+			let minusTwo = fn() { let num = 2; globalSeed - num; };
+			minusOne() + minusTwo();
+			`,
+			97,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+// as many locals and arguments as the operands hold, the last ones are not mixed up with the first
+func TestLimits(t *testing.T) {
+	names := make([]string, 255)
+	lets := ""
+	values := make([]string, 255)
+	for i := range names {
+		names[i] = "v" + string(rune('a'+i/26)) + string(rune('a'+i%26))
+		lets += fmt.Sprintf("let %s = %d; ", names[i], i)
+		values[i] = fmt.Sprint(i)
+	}
+
+	tests := []vmTestCase{
+		{"fn() { " + lets + names[0] + " + " + names[254] + " }()", 254},
+		{"fn() { " + lets + names[43] + " + " + names[254] + " }()", 297},
+		{"fn(" + strings.Join(names, ", ") + ") { " + names[254] + " - " + names[0] + " }(" + strings.Join(values, ", ") + ")", 254},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			`
+			let newAdder = fn(a, b) {
+				fn(c) { a + b + c };
+			};
+			let adder = newAdder(1, 2);
+			adder(8);
+			`,
+			11,
+		},
+		{
+			`
+			let wrapper = fn() {
+				let countDown = fn(x) {
+					if (x == 0) { return 0; } else { countDown(x - 1); }
+				};
+				countDown(1);
+			};
+			wrapper();
+			`,
+			0,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`first([])`, Null},
+		{`rest([1, 2, 3])[0]`, 2},
+		{`puts("hello")`, Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"5 + true;", "type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "unknown operator: -BOOLEAN"},
+		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{"fn() { 1; }(1);", "wrong number of arguments: want=0, got=1"},
+		{"1()", "not a function: INTEGER"},
+		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{"let f = fn() { f() }; f()", "stack overflow: more than 1024 nested calls"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Errorf("expected vm error for %q", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong vm error. want=%q, got=%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		err := comp.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		stackElem := vm.LastPoppedStackElem()
+
+		testExpectedObject(t, tt.expected, stackElem)
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		result, ok := actual.(*object.Integer)
+		if !ok {
+			t.Errorf("object is not Integer. got=%T (%+v)", actual, actual)
+			return
+		}
+		if result.Value != int64(expected) {
+			t.Errorf("object has wrong value. got=%d, want=%d", result.Value, expected)
+		}
+
+	case bool:
+		result, ok := actual.(*object.Boolean)
+		if !ok {
+			t.Errorf("object is not Boolean. got=%T (%+v)", actual, actual)
+			return
+		}
+		if result.Value != expected {
+			t.Errorf("object has wrong value. got=%t, want=%t", result.Value, expected)
+		}
+
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("object is not Null: %T (%+v)", actual, actual)
+		}
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	comp := compiler.New()
+	if err := comp.Compile(parse("let f = fn(x) { f(x) + 1 }; let g = fn() { f(1); g() }; g()")); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	vm := New(comp.Bytecode())
+	err := vm.RunContext(ctx)
+	if err == nil || err.Error() != "execution cancelled" {
+		t.Errorf("wrong vm error. want=%q, got=%v", "execution cancelled", err)
+	}
+}